@@ -0,0 +1,188 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/xfix/showdown2irc/irc"
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// sendBattleLog sends lines of battle log that were added since it was
+// last sent.
+func (c *connection) sendBattleLog(room *showdown.Room) {
+	if room == nil || room.Battle == nil {
+		return
+	}
+	lines := room.Battle.TakeLines()
+	switch c.battleView(room.ID) {
+	case compactView:
+		c.sendCompactBattleLog(room, lines)
+		return
+	case resultsView:
+		c.sendBattleResults(room, lines)
+		return
+	}
+	channel := escapeRoom(room.ID)
+	for _, line := range lines {
+		text := line.Text
+		if line.Heading {
+			text = "\x02" + text
+		}
+		c.sendGlobal("NOTICE", channel, text)
+	}
+}
+
+// showBattleRequest shows options that can be chosen in a battle, as
+// numbered lists.
+func (c *connection) showBattleRequest(room *showdown.Room) {
+	if room.Battle == nil || room.Battle.Request() == nil {
+		return
+	}
+	request := room.Battle.Request()
+	c.setLastBattle(room.ID)
+	if request.Wait {
+		return
+	}
+	channel := escapeRoom(room.ID)
+	side := request.Side.Pokemon
+	switch {
+	case request.TeamPreview:
+		var team []string
+		for i, pokemon := range side {
+			team = append(team, fmt.Sprintf("%d. %s", i+1, pokemon.Species()))
+		}
+		c.sendGlobal("NOTICE", channel, "Team preview: "+strings.Join(team, ", "))
+		c.sendGlobal("NOTICE", channel, "Choose the order of your team with /team, like /team 123456")
+	case forcedSwitch(request):
+		c.sendGlobal("NOTICE", channel, "Switch: "+switchOptions(side))
+		c.sendGlobal("NOTICE", channel, "Choose a Pokémon with /switch")
+	default:
+		trapped := false
+		for i, active := range request.Active {
+			name := ""
+			if i < len(side) {
+				name = side[i].Name() + " "
+			}
+			var moves []string
+			for j, move := range active.Moves {
+				option := fmt.Sprintf("%d. %s", j+1, move.Move)
+				if move.Disabled {
+					option += " (disabled)"
+				} else if move.MaxPP != 0 {
+					option += fmt.Sprintf(" (%d/%d PP)", move.PP, move.MaxPP)
+				}
+				moves = append(moves, option)
+			}
+			c.sendGlobal("NOTICE", channel, fmt.Sprintf("%smoves: %s", name, strings.Join(moves, ", ")))
+			trapped = trapped || active.Trapped
+		}
+		if options := switchOptions(side); options != "" && !trapped {
+			c.sendGlobal("NOTICE", channel, "Switch: "+options)
+		}
+		c.sendGlobal("NOTICE", channel, "Choose an action with /move or /switch")
+	}
+}
+
+func forcedSwitch(request *showdown.BattleRequest) bool {
+	for _, forced := range request.ForceSwitch {
+		if forced {
+			return true
+		}
+	}
+	return false
+}
+
+// switchOptions lists Pokémon that can be switched in, numbered by
+// their position in a team.
+func switchOptions(side []showdown.SidePokemon) string {
+	var buffer bytes.Buffer
+	for i, pokemon := range side {
+		if pokemon.Active || pokemon.Fainted() {
+			continue
+		}
+		if buffer.Len() != 0 {
+			buffer.WriteString(", ")
+		}
+		fmt.Fprintf(&buffer, "%d. %s (%s)", i+1, pokemon.Name(), pokemon.Condition)
+	}
+	return buffer.String()
+}
+
+// battleRoom finds a battle room for a battle command, either given as
+// the first parameter, or the last battle that requested a decision.
+// It returns the room and remaining parameters.
+func (c *connection) battleRoom(params []string) (*showdown.Room, []string) {
+	name := string(c.getLastBattle())
+	if len(params) > 0 && strings.HasPrefix(params[0], "#") {
+		name = params[0][1:]
+		params = params[1:]
+	}
+	room, ok := c.showdown.JoinedRoom(name)
+	if name == "" || !ok || room.Battle == nil {
+		c.sendNumericReason(irc.ErrNotOnChannel, escapeRoom(showdown.RoomID(name)), "You aren't in this battle")
+		return nil, nil
+	}
+	return room, params
+}
+
+// setLastBattle remembers the last battle that requested a decision.
+func (c *connection) setLastBattle(room showdown.RoomID) {
+	c.lastRoomMutex.Lock()
+	defer c.lastRoomMutex.Unlock()
+	c.lastBattle = room
+}
+
+func (c *connection) getLastBattle() showdown.RoomID {
+	c.lastRoomMutex.Lock()
+	defer c.lastRoomMutex.Unlock()
+	return c.lastBattle
+}
+
+// battleChoice creates an IRC command that makes a decision in a battle,
+// like /move 1.
+func battleChoice(choice string) func(*connection, []string) {
+	return func(c *connection, command []string) {
+		room, params := c.battleRoom(command)
+		if room == nil {
+			return
+		}
+		if len(params) == 0 {
+			c.needMoreParams(strings.ToUpper(choice))
+			return
+		}
+		decision := choice + " " + strings.Join(params, " ")
+		if request := room.Battle.Request(); request != nil && request.RequestID != 0 {
+			decision += fmt.Sprintf("|%d", request.RequestID)
+		}
+		room.SendCommand("choose", decision)
+	}
+}
+
+// battleCommand creates an IRC command that runs a Showdown command in
+// a battle room, like /forfeit.
+func battleCommand(name string) func(*connection, []string) {
+	return func(c *connection, command []string) {
+		room, params := c.battleRoom(command)
+		if room != nil {
+			room.SendCommand(name, strings.Join(params, " "))
+		}
+	}
+}
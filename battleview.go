@@ -0,0 +1,222 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// battleView specifies how much of a battle log is shown.
+type battleView int
+
+const (
+	// fullView shows every line of a battle log.
+	fullView battleView = iota
+	// compactView summarizes each turn in one or two lines of moves,
+	// switches and fainted Pokémon.
+	compactView
+	// resultsView shows only team preview and winner, and a replay link
+	// when saveResultReplays is set.
+	resultsView
+)
+
+var battleViewNames = []string{"full", "compact", "results"}
+
+// The view used for battles when a room doesn't have its own
+var defaultBattleView = fullView
+
+// Whether battles shown in results view are uploaded as replays once
+// they end. Off by default, as it publishes battles of other users.
+var saveResultReplays = false
+
+func (v battleView) String() string {
+	return battleViewNames[v]
+}
+
+func parseBattleView(name string) (battleView, error) {
+	for i, viewName := range battleViewNames {
+		if strings.EqualFold(name, viewName) {
+			return battleView(i), nil
+		}
+	}
+	return fullView, fmt.Errorf("unknown battle view %s, use %s", name, strings.Join(battleViewNames, ", "))
+}
+
+// Commands whose lines are shown in compact view, and whether they are
+// moves, which are displayed in the first line of a summary
+var compactCommands = map[string]bool{
+	"move":    true,
+	"switch":  false,
+	"drag":    false,
+	"replace": false,
+	"faint":   false,
+}
+
+// Commands whose lines are shown on their own in compact and results
+// views
+var resultCommands = map[string]bool{
+	"start":       true,
+	"teampreview": true,
+	"win":         true,
+	"tie":         true,
+}
+
+// turnSummary collects lines of a turn shown in compact view.
+type turnSummary struct {
+	heading string
+	moves   []string
+	events  []string
+}
+
+func (s *turnSummary) lines() []string {
+	var lines []string
+	prefix := ""
+	if s.heading != "" {
+		prefix = "\x02" + s.heading + ":\x02 "
+	}
+	for _, texts := range [][]string{s.moves, s.events} {
+		if len(texts) != 0 {
+			lines = append(lines, prefix+strings.Join(texts, " "))
+			prefix = ""
+		}
+	}
+	return lines
+}
+
+// battleView returns a view of a battle, or the default view when
+// room is empty.
+func (c *connection) battleView(room showdown.RoomID) battleView {
+	c.battleViewMutex.Lock()
+	defer c.battleViewMutex.Unlock()
+	if view, ok := c.battleViews[roomKey(room)]; ok && room != "" {
+		return view
+	}
+	return c.defaultBattleView
+}
+
+// setBattleView changes a view of a battle, or the default view when
+// room is empty.
+func (c *connection) setBattleView(room showdown.RoomID, view battleView) {
+	c.battleViewMutex.Lock()
+	defer c.battleViewMutex.Unlock()
+	if room == "" {
+		c.defaultBattleView = view
+		return
+	}
+	if c.battleViews == nil {
+		c.battleViews = map[string]battleView{}
+	}
+	c.battleViews[roomKey(room)] = view
+}
+
+// forgetBattleView removes a view of a battle which was left.
+func (c *connection) forgetBattleView(room showdown.RoomID) {
+	c.battleViewMutex.Lock()
+	defer c.battleViewMutex.Unlock()
+	delete(c.battleViews, roomKey(room))
+}
+
+// sendCompactBattleLog sends summaries of turns. As a turn only ends
+// when the next one starts, it's summarized then.
+func (c *connection) sendCompactBattleLog(room *showdown.Room, lines []showdown.BattleLine) {
+	channel := escapeRoom(room.ID)
+	key := roomKey(room.ID)
+	summary := c.turnSummaries[key]
+	flush := func() {
+		if summary != nil {
+			for _, text := range summary.lines() {
+				c.sendGlobal("NOTICE", channel, text)
+			}
+		}
+		summary = nil
+	}
+	for _, line := range lines {
+		isMove, shown := compactCommands[line.Command]
+		switch {
+		case line.Command == "turn":
+			flush()
+			summary = &turnSummary{heading: line.Text}
+		case resultCommands[line.Command]:
+			flush()
+			c.sendGlobal("NOTICE", channel, "\x02"+line.Text)
+		case shown:
+			if summary == nil {
+				summary = &turnSummary{}
+			}
+			if isMove {
+				summary.moves = append(summary.moves, line.Text)
+			} else {
+				summary.events = append(summary.events, line.Text)
+			}
+		}
+	}
+	if summary == nil {
+		delete(c.turnSummaries, key)
+	} else {
+		if c.turnSummaries == nil {
+			c.turnSummaries = map[string]*turnSummary{}
+		}
+		c.turnSummaries[key] = summary
+	}
+}
+
+// sendBattleResults sends team preview and the result of a battle. When
+// saveResultReplays is set, a replay is saved once a battle ends, so
+// that a link to it is shown.
+func (c *connection) sendBattleResults(room *showdown.Room, lines []showdown.BattleLine) {
+	for _, line := range lines {
+		if line.Command == "start" || !resultCommands[line.Command] {
+			continue
+		}
+		c.sendGlobal("NOTICE", escapeRoom(room.ID), "\x02"+line.Text)
+		if saveResultReplays && (line.Command == "win" || line.Command == "tie") {
+			room.SendCommand("savereplay", "")
+		}
+	}
+}
+
+// battleViewCommand shows or changes a view of a battle room, or the
+// default view when a room isn't given.
+func battleViewCommand(c *connection, command []string) {
+	var room showdown.RoomID
+	if len(command) > 0 && strings.HasPrefix(command[0], "#") {
+		room = showdown.RoomID(command[0][1:])
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		if room == "" {
+			c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("Default battle view: %s", c.battleView("")))
+		} else {
+			c.sendGlobal("NOTICE", escapeRoom(room), fmt.Sprintf("Battle view: %s", c.battleView(room)))
+		}
+		return
+	}
+	view, err := parseBattleView(command[0])
+	if err != nil {
+		c.sendGlobal("NOTICE", c.nickname, err.Error())
+		return
+	}
+	c.setBattleView(room, view)
+	if room == "" {
+		c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("Default battle view set to %s", view))
+		return
+	}
+	c.sendGlobal("NOTICE", escapeRoom(room), fmt.Sprintf("Battle view set to %s", view))
+}
@@ -0,0 +1,107 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/xfix/showdown2irc/irc"
+)
+
+// IRCv3 capabilities understood by the proxy, in order in which they
+// are advertised by CAP LS.
+var supportedCapabilities = []string{
+	"echo-message",
+	"message-tags",
+	"draft/message-redaction",
+}
+
+func isSupportedCapability(name string) bool {
+	for _, capability := range supportedCapabilities {
+		if capability == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *connection) hasCapability(name string) bool {
+	return c.capabilities[name]
+}
+
+var capCommands = map[string]func(*connection, []string){
+	"LS": func(c *connection, command []string) {
+		// Registration is suspended until CAP END, as otherwise the
+		// client could miss capabilities it asked for.
+		if !c.registered {
+			c.capNegotiating = true
+		}
+		c.sendGlobal("CAP", c.nickname, "LS", strings.Join(supportedCapabilities, " "))
+	},
+	"LIST": func(c *connection, command []string) {
+		var enabled []string
+		for _, capability := range supportedCapabilities {
+			if c.hasCapability(capability) {
+				enabled = append(enabled, capability)
+			}
+		}
+		c.sendGlobal("CAP", c.nickname, "LIST", strings.Join(enabled, " "))
+	},
+	"REQ": func(c *connection, command []string) {
+		if len(command) < 1 {
+			c.needMoreParams("CAP")
+			return
+		}
+		if !c.registered {
+			c.capNegotiating = true
+		}
+		requested := strings.Fields(command[0])
+		// The request is atomic, either all capabilities are changed,
+		// or none of them.
+		for _, capability := range requested {
+			if !isSupportedCapability(strings.TrimPrefix(capability, "-")) {
+				c.sendGlobal("CAP", c.nickname, "NAK", command[0])
+				return
+			}
+		}
+		for _, capability := range requested {
+			if strings.HasPrefix(capability, "-") {
+				delete(c.capabilities, capability[1:])
+			} else {
+				c.capabilities[capability] = true
+			}
+		}
+		c.sendGlobal("CAP", c.nickname, "ACK", command[0])
+	},
+	"END": func(c *connection, command []string) {
+		c.capNegotiating = false
+		c.tryRegistration()
+	},
+}
+
+func capCommand(c *connection, command []string) {
+	if len(command) < 1 {
+		c.needMoreParams("CAP")
+		return
+	}
+	subcommand := strings.ToUpper(command[0])
+	if callback, ok := capCommands[subcommand]; ok {
+		callback(c, command[1:])
+	} else {
+		c.sendNumeric(irc.ErrInvalidCapCmd, subcommand)
+	}
+}
@@ -0,0 +1,79 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// showChallenges notifies about challenges that weren't seen before.
+func (c *connection) showChallenges(challenges showdown.Challenges) {
+	var challengers []string
+	for challenger := range challenges.From {
+		if _, ok := c.knownChallenges[challenger]; !ok {
+			challengers = append(challengers, string(challenger))
+		}
+	}
+	sort.Strings(challengers)
+	for _, challenger := range challengers {
+		format := challenges.From[showdown.UserID(challenger)]
+		c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("%s challenges you to a %s battle. Use /accept %s or /reject %s", challenger, format, challenger, challenger))
+	}
+	c.knownChallenges = challenges.From
+}
+
+// joinNewBattles joins battles that were started, so that they appear
+// as channels.
+func (c *connection) joinNewBattles(search showdown.Search) {
+	for id := range search.Games {
+		if _, ok := c.knownGames[id]; !ok && !c.showdown.InRoom(id) {
+			c.addPendingCommand(id, "JOIN")
+			c.showdown.SendGlobalCommand("join", string(id))
+		}
+	}
+	c.knownGames = search.Games
+}
+
+// showdownCommand creates an IRC command that runs a global Showdown
+// command, with parameters joined with commas.
+func showdownCommand(name string, minimumParams int) func(*connection, []string) {
+	return func(c *connection, command []string) {
+		if len(command) < minimumParams {
+			c.needMoreParams(strings.ToUpper(name))
+			return
+		}
+		params := make([]string, len(command))
+		for i, param := range command {
+			params[i] = unescapeUser(param)
+		}
+		c.showdown.SendGlobalCommand(name, strings.Join(params, ", "))
+	}
+}
+
+func cancelChallenge(c *connection, command []string) {
+	user := ""
+	if len(command) > 0 {
+		user = unescapeUser(command[0])
+	} else if challenge := c.showdown.Challenges().To; challenge != nil {
+		user = string(challenge.To)
+	}
+	c.showdown.SendGlobalCommand("cancelchallenge", user)
+}
@@ -0,0 +1,277 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package chatformat converts between Showdown chat markup and IRC
+// formatting codes.
+package chatformat
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// IRC formatting codes
+const (
+	boldCode          = "\x02"
+	italicCode        = "\x1D"
+	strikethroughCode = "\x1E"
+	monospaceCode     = "\x11"
+	reverseCode       = "\x16"
+)
+
+// Showdown delimiters which surround formatted text, with IRC codes that
+// represent them. IRC doesn't support superscript and subscript, so the
+// text is displayed without any formatting.
+var spanDelimiters = []struct {
+	delimiter, code string
+}{
+	{"**", boldCode},
+	{"__", italicCode},
+	{"~~", strikethroughCode},
+	{"^^", ""},
+	{`\\`, ""},
+	{"||", reverseCode},
+}
+
+type openSpan struct {
+	delimiter, code string
+	token           int
+}
+
+// chatConverter converts a Showdown chat message. The output is stored
+// as tokens, so delimiters that turn out to be unclosed can be left as
+// they are.
+type chatConverter struct {
+	message   string
+	textStart int
+	tokens    []string
+	stack     []openSpan
+}
+
+// ToIRC converts Showdown chat message into IRC text.
+//
+// Showdown markup is converted to IRC formatting codes where possible,
+// links in [[double brackets]] are expanded to text followed by a URL
+// in angle brackets, and <<room>> links are changed to IRC channel
+// names.
+func ToIRC(message string) string {
+	c := chatConverter{message: message}
+	i := 0
+	for i < len(message) {
+		consumed := c.parseCode(i)
+		if consumed == 0 {
+			consumed = c.parseLink(i)
+		}
+		if consumed == 0 {
+			consumed = c.parseRoomLink(i)
+		}
+		if consumed == 0 {
+			consumed = c.parseSpan(i)
+		}
+		if consumed == 0 {
+			i++
+		} else {
+			i += consumed
+			c.textStart = i
+		}
+	}
+	c.flush(len(message))
+	return strings.Join(c.tokens, "")
+}
+
+// flush adds text that wasn't yet processed up to a given position.
+func (c *chatConverter) flush(end int) {
+	if c.textStart < end {
+		c.tokens = append(c.tokens, c.message[c.textStart:end])
+	}
+}
+
+// parseCode handles `code`. The content of code isn't formatted. Code
+// can use any number of backticks, allowing backticks in code itself.
+func (c *chatConverter) parseCode(start int) int {
+	rest := c.message[start:]
+	backticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+	if backticks == 0 {
+		return 0
+	}
+	delimiter := rest[:backticks]
+	end := backticks
+	for {
+		found := strings.Index(rest[end:], delimiter)
+		if found < 0 {
+			return 0
+		}
+		end += found
+		// The closing delimiter has to be exactly as long as opening one
+		if !strings.HasPrefix(rest[end+backticks:], "`") {
+			break
+		}
+		end += len(rest[end:]) - len(strings.TrimLeft(rest[end:], "`"))
+	}
+	code := rest[backticks:end]
+	if strings.TrimSpace(code) == "" {
+		return 0
+	}
+	// Single spaces around code allow starting it with a backtick
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+		code = code[1 : len(code)-1]
+	}
+	c.flush(start)
+	c.tokens = append(c.tokens, monospaceCode, code, monospaceCode)
+	return end + backticks
+}
+
+// parseLink handles [[links]]. Links can have a form of [[text<url>]],
+// or [[search term]], which links to a search result.
+func (c *chatConverter) parseLink(start int) int {
+	rest := c.message[start:]
+	if !strings.HasPrefix(rest, "[[") {
+		return 0
+	}
+	end := strings.Index(rest, "]]")
+	if end < 0 {
+		return 0
+	}
+	content := strings.TrimSpace(rest[2:end])
+	if content == "" {
+		return 0
+	}
+	var text, link string
+	if open := strings.LastIndex(content, "<"); open >= 0 && strings.HasSuffix(content, ">") {
+		text = strings.TrimSpace(content[:open])
+		link = content[open+1 : len(content)-1]
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+		if text == "" {
+			text = link
+		}
+	} else {
+		text = content
+		link = searchLink(content)
+	}
+	c.flush(start)
+	if text == link {
+		c.tokens = append(c.tokens, link)
+	} else {
+		c.tokens = append(c.tokens, fmt.Sprintf("%s <%s>", text, link))
+	}
+	return end + len("]]")
+}
+
+// Prefixes in [[links]] that link to a specific site instead of
+// a search engine.
+var linkPrefixes = map[string]string{
+	"wiki":    "https://en.wikipedia.org/w/index.php?title=Special:Search&search=",
+	"pokemon": "https://dex.pokemonshowdown.com/pokemon/",
+	"item":    "https://dex.pokemonshowdown.com/items/",
+}
+
+func searchLink(term string) string {
+	if colon := strings.Index(term, ":"); colon >= 0 {
+		prefix := strings.ToLower(strings.TrimSpace(term[:colon]))
+		if site, ok := linkPrefixes[prefix]; ok {
+			query := strings.TrimSpace(term[colon+1:])
+			if prefix == "wiki" {
+				return site + url.QueryEscape(query)
+			}
+			return site + string(showdown.ToID(query))
+		}
+	}
+	return "https://www.google.com/search?ie=UTF-8&btnI&q=" + url.QueryEscape(term)
+}
+
+// parseRoomLink handles <<room>> links, which are changed to IRC
+// channel names.
+func (c *chatConverter) parseRoomLink(start int) int {
+	rest := c.message[start:]
+	if !strings.HasPrefix(rest, "<<") {
+		return 0
+	}
+	end := strings.Index(rest, ">>")
+	if end < 0 {
+		return 0
+	}
+	room := rest[2:end]
+	if room == "" || showdown.ToRoomID(room) != showdown.RoomID(room) {
+		return 0
+	}
+	c.flush(start)
+	c.tokens = append(c.tokens, "#"+room)
+	return end + len(">>")
+}
+
+// parseSpan handles delimiters surrounding formatted text, like **bold**.
+//
+// An opening delimiter cannot be followed by a space, and a closing
+// delimiter cannot be preceded by a space. Delimiters that don't have
+// a pair are displayed as they are.
+func (c *chatConverter) parseSpan(start int) int {
+	rest := c.message[start:]
+	for _, span := range spanDelimiters {
+		if !strings.HasPrefix(rest, span.delimiter) {
+			continue
+		}
+		length := len(span.delimiter)
+		for i := len(c.stack) - 1; i >= 0; i-- {
+			if c.stack[i].delimiter != span.delimiter {
+				continue
+			}
+			if c.stack[i].token == len(c.tokens)-1 && c.textStart == start || precededBySpace(c.message[:start]) {
+				// Empty span or space before delimiter
+				break
+			}
+			c.flush(start)
+			c.tokens[c.stack[i].token] = span.code
+			c.tokens = append(c.tokens, span.code)
+			// Unclosed spans inside a closed span stay as text
+			c.stack = c.stack[:i]
+			return length
+		}
+		if followedBySpace(rest[length:]) || c.isOpen(span.delimiter) {
+			return 0
+		}
+		c.flush(start)
+		c.stack = append(c.stack, openSpan{span.delimiter, span.code, len(c.tokens)})
+		c.tokens = append(c.tokens, span.delimiter)
+		return length
+	}
+	return 0
+}
+
+func (c *chatConverter) isOpen(delimiter string) bool {
+	for _, span := range c.stack {
+		if span.delimiter == delimiter {
+			return true
+		}
+	}
+	return false
+}
+
+func precededBySpace(text string) bool {
+	character, _ := utf8.DecodeLastRuneInString(text)
+	return text == "" || unicode.IsSpace(character)
+}
+
+func followedBySpace(text string) bool {
+	character, _ := utf8.DecodeRuneInString(text)
+	return text == "" || unicode.IsSpace(character)
+}
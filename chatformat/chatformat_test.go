@@ -0,0 +1,116 @@
+package chatformat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var toIRCTests = []struct {
+	in  string
+	out string
+}{
+	{"", ""},
+	{"plain text", "plain text"},
+	{"**bold**", "\x02bold\x02"},
+	{"__italic__ and ~~strike~~", "\x1Ditalic\x1D and \x1Estrike\x1E"},
+	{"^^sup^^ \\\\sub\\\\", "sup sub"},
+	{"||spoiler||", "\x16spoiler\x16"},
+	{"**bold __both__**", "\x02bold \x1Dboth\x1D\x02"},
+	{"** not bold**", "** not bold**"},
+	{"**not bold **", "**not bold **"},
+	{"****", "****"},
+	{"**unclosed", "**unclosed"},
+	{"**a __b**", "\x02a __b\x02"},
+	{"2 ** 3 ** 4", "2 ** 3 ** 4"},
+	{"`**code**`", "\x11**code**\x11"},
+	{"``a ` b``", "\x11a ` b\x11"},
+	{"`` `a` ``", "\x11`a`\x11"},
+	{"`unclosed", "`unclosed"},
+	{"[[Pikachu]]", "Pikachu <https://www.google.com/search?ie=UTF-8&btnI&q=Pikachu>"},
+	{"[[Showdown <pokemonshowdown.com>]]", "Showdown <http://pokemonshowdown.com>"},
+	{"[[pokemon: Mr. Mime]]", "pokemon: Mr. Mime <https://dex.pokemonshowdown.com/pokemon/mrmime>"},
+	{"[[wiki: Go]]", "wiki: Go <https://en.wikipedia.org/w/index.php?title=Special:Search&search=Go>"},
+	{"**[[a]]**", "\x02a <https://www.google.com/search?ie=UTF-8&btnI&q=a>\x02"},
+	{"[[<pokemonshowdown.com>]]", "http://pokemonshowdown.com"},
+	{"[[unclosed", "[[unclosed"},
+	{"join <<techcode>>", "join #techcode"},
+	{"<<not a room>>", "<<not a room>>"},
+	{"ĄĘ**Ź**", "ĄĘ\x02Ź\x02"},
+}
+
+func TestToIRC(t *testing.T) {
+	for _, test := range toIRCTests {
+		assert.Equal(t, ToIRC(test.in), test.out, "ToIRC(%#q)", test.in)
+	}
+}
+
+var fromIRCTests = []struct {
+	in  string
+	out string
+}{
+	{"", ""},
+	{"plain text", "plain text"},
+	{"\x02bold\x02", "**bold**"},
+	{"\x02bold", "**bold**"},
+	{"\x1Ditalic\x0F and \x1Estrike", "__italic__ and ~~strike~~"},
+	{"\x02 spaced \x02out", " **spaced** out"},
+	{"\x02\x02empty", "empty"},
+	{"\x02a\x1Db\x02c\x1D", "**a__b__**__c__"},
+	{"\x16spoiler\x16", "||spoiler||"},
+	{"\x0304,12colored\x03 \x04FF0000red\x1Funderline", "colored redunderline"},
+	{"\x11**code**\x11", "`**code**`"},
+	{"\x11a ` b\x11", "``a ` b``"},
+	{"\x11`a`\x11", "`` `a` ``"},
+	{"2 ** 3 __init__", "2 ** 3 _\u200B_init__"},
+	{"[[not a link]]", "[\u200B[not a link]]"},
+	{"a**b ~~ c [[", "a**b ~~ c [["},
+	{"\x02a** b\x02", "**a*\u200B* b**"},
+	{"don't`", "don't`"},
+	{"`a` b", "`` ` ``a`` ` `` b"},
+	{"see https://example.com/__init__", "see https://example.com/__init__"},
+}
+
+func TestFromIRC(t *testing.T) {
+	for _, test := range fromIRCTests {
+		assert.Equal(t, FromIRC(test.in), test.out, "FromIRC(%#q)", test.in)
+	}
+}
+
+// Text without IRC formatting should be displayed by Showdown exactly as
+// it was written.
+var plainTexts = []string{
+	"2 ** 3 __init__",
+	"[[not a link]]",
+	"<<lobby>>",
+	"~~a~~ ^^b^^ \\\\c\\\\ ||d||",
+	"**a __b** c__",
+	"don't`",
+}
+
+func TestFromIRCRendering(t *testing.T) {
+	for _, text := range plainTexts {
+		rendered := strings.Replace(ToIRC(FromIRC(text)), zeroWidthSpace, "", -1)
+		assert.Equal(t, rendered, text, "ToIRC(FromIRC(%#q))", text)
+	}
+}
+
+var splitFromIRCTests = []struct {
+	in    string
+	limit int
+	out   []string
+}{
+	{"short", 10, []string{"short"}},
+	{"\x02bold text here\x02 plain", 12, []string{"**bold**", "**text**", "**here**", "plain"}},
+	{"\x02a\x1Db c\x0F d", 8, []string{"**a**", "**__b__**", "**__c__**", "d"}},
+	{"\x11code that is long\x11", 12, []string{"`code that`", "`is long`"}},
+	{"2 ** 3 ** 4", 6, []string{"2 ** 3", "** 4"}},
+	{"  ", 10, nil},
+}
+
+func TestSplitFromIRC(t *testing.T) {
+	for _, test := range splitFromIRCTests {
+		assert.Equal(t, SplitFromIRC(test.in, test.limit), test.out, "SplitFromIRC(%#q, %d)", test.in, test.limit)
+	}
+}
@@ -0,0 +1,316 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chatformat
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+const (
+	colorCode    = '\x03'
+	hexColorCode = '\x04'
+	resetCode    = '\x0F'
+)
+
+// Showdown delimiters for IRC formatting codes. Monospace is handled
+// separately, as formatting cannot be used in code.
+var ircDelimiters = map[byte]string{
+	boldCode[0]:          "**",
+	italicCode[0]:        "__",
+	strikethroughCode[0]: "~~",
+	reverseCode[0]:       "||",
+}
+
+// Sequences that could be understood by Showdown as formatting, and
+// thus may need to be escaped.
+var markupSequences = []string{"**", "__", "~~", "^^", `\\`, "||", "[[", "<<"}
+
+// Closing sequences of links, other sequences are closed by themselves.
+var closingSequences = map[string]string{"[[": "]]", "<<": ">>"}
+
+// zeroWidthSpace splits sequences that would be understood as markup.
+// Unlike other escapes, it isn't displayed.
+const zeroWidthSpace = "\u200B"
+
+var (
+	colorRegexp    = regexp.MustCompile(`^\x03(\d{1,2}(,\d{1,2})?)?`)
+	hexColorRegexp = regexp.MustCompile(`^\x04([0-9A-Fa-f]{6}(,[0-9A-Fa-f]{6})?)?`)
+	urlRegexp      = regexp.MustCompile(`^(https?://|www\.)[^\s\x00-\x1F]*`)
+)
+
+type ircConverter struct {
+	bytes.Buffer
+	// Delimiters of currently open spans, in order of opening
+	open []string
+	// Spans that were enabled, but not written, as Showdown doesn't
+	// allow spaces after opening delimiters
+	pending []string
+	// Contents of monospace text, nil when not in monospace
+	code           *bytes.Buffer
+	escapeBacktick bool
+}
+
+// FromIRC converts IRC text into Showdown chat message.
+//
+// IRC formatting codes are converted to Showdown markup. Codes that
+// cannot be represented in Showdown, like colors and underline, are
+// removed. Text that would be accidentally understood by Showdown as
+// markup is escaped.
+func FromIRC(message string) string {
+	c := ircConverter{escapeBacktick: strings.Count(message, "`") > 1}
+	for i := 0; i < len(message); {
+		character := message[i]
+		switch {
+		case character == colorCode:
+			i += len(colorRegexp.FindString(message[i:]))
+		case character == hexColorCode:
+			i += len(hexColorRegexp.FindString(message[i:]))
+		case character == resetCode:
+			c.closeCode()
+			c.closeAll()
+			i++
+		case character == monospaceCode[0]:
+			if c.code == nil {
+				c.code = new(bytes.Buffer)
+			} else {
+				c.closeCode()
+			}
+			i++
+		case ircDelimiters[character] != "":
+			if c.code == nil {
+				c.toggle(ircDelimiters[character])
+			}
+			i++
+		case character < ' ':
+			// Other control codes, like underline, cannot be represented
+			i++
+		case c.code != nil:
+			c.code.WriteByte(character)
+			i++
+		default:
+			i += c.writeText(message[i:])
+		}
+	}
+	c.closeCode()
+	c.closeAll()
+	return c.String()
+}
+
+// SplitFromIRC converts IRC text like FromIRC, splitting it into
+// Showdown chat messages no longer than limit. Formatting that is
+// active where a message was split is enabled again in the next part.
+func SplitFromIRC(message string, limit int) []string {
+	var parts []string
+	state := ""
+	for {
+		message = strings.TrimLeftFunc(message, unicode.IsSpace)
+		if message == "" {
+			return parts
+		}
+		var piece, converted string
+		for pieceLimit := limit; ; {
+			pieces := showdown.SplitMessage(message, pieceLimit)
+			if len(pieces) == 0 {
+				return parts
+			}
+			piece = pieces[0]
+			converted = FromIRC(state + piece)
+			// Markup may make the message longer than IRC text
+			excess := showdown.MessageLength(converted) - limit
+			if excess <= 0 || pieceLimit == 1 {
+				break
+			}
+			pieceLimit -= excess
+			if pieceLimit < 1 {
+				pieceLimit = 1
+			}
+		}
+		// Formatting codes may leave spaces at the start
+		if converted = strings.TrimLeftFunc(converted, unicode.IsSpace); converted != "" {
+			parts = append(parts, converted)
+		}
+		state = activeFormatting(state + piece)
+		message = message[len(piece):]
+	}
+}
+
+// activeFormatting returns IRC formatting codes which are enabled at
+// the end of IRC text.
+func activeFormatting(text string) string {
+	var active []byte
+	for i := 0; i < len(text); i++ {
+		character := text[i]
+		switch {
+		case character == resetCode:
+			active = nil
+		case character == monospaceCode[0] || ircDelimiters[character] != "":
+			if j := bytes.IndexByte(active, character); j >= 0 {
+				active = append(active[:j], active[j+1:]...)
+			} else {
+				active = append(active, character)
+			}
+		}
+	}
+	return string(active)
+}
+
+// writeText writes a part of text, escaping it if necessary. It returns
+// number of bytes consumed.
+func (c *ircConverter) writeText(text string) int {
+	if url := urlRegexp.FindString(text); url != "" && precededBySpace(c.String()) {
+		// Showdown doesn't format links, so they don't need escaping
+		c.writePending()
+		c.WriteString(url)
+		return len(url)
+	}
+	for _, sequence := range markupSequences {
+		if strings.HasPrefix(text, sequence) {
+			c.writePending()
+			if c.closesSpan(sequence) || opensMarkup(sequence, text[len(sequence):]) {
+				c.WriteString(sequence[:1] + zeroWidthSpace + sequence[1:])
+			} else {
+				c.WriteString(sequence)
+			}
+			return len(sequence)
+		}
+	}
+	character, size := utf8.DecodeRuneInString(text)
+	if character == '`' && c.escapeBacktick {
+		c.writePending()
+		c.WriteString("`` ` ``")
+		return size
+	}
+	if !unicode.IsSpace(character) {
+		c.writePending()
+	}
+	c.WriteString(text[:size])
+	return size
+}
+
+// closesSpan checks whether a sequence would close a span that was
+// opened by IRC formatting.
+func (c *ircConverter) closesSpan(sequence string) bool {
+	if precededBySpace(c.String()) {
+		return false
+	}
+	for _, open := range c.open {
+		if open == sequence {
+			return true
+		}
+	}
+	return false
+}
+
+// opensMarkup checks whether a sequence followed by given text would
+// be understood as markup, which happens only when it has a pair.
+func opensMarkup(sequence, rest string) bool {
+	if closing, ok := closingSequences[sequence]; ok {
+		end := strings.Index(rest, closing)
+		return end >= 0 && strings.TrimSpace(rest[:end]) != ""
+	}
+	if followedBySpace(rest) {
+		return false
+	}
+	for i := 1; i < len(rest); i++ {
+		if strings.HasPrefix(rest[i:], sequence) && !precededBySpace(rest[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// writePending writes opening delimiters that were delayed.
+func (c *ircConverter) writePending() {
+	for _, delimiter := range c.pending {
+		c.WriteString(delimiter)
+		c.open = append(c.open, delimiter)
+	}
+	c.pending = nil
+}
+
+func (c *ircConverter) toggle(delimiter string) {
+	for i, pending := range c.pending {
+		if pending == delimiter {
+			// Nothing was written yet, so the span can be just dropped
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return
+		}
+	}
+	for i, open := range c.open {
+		if open == delimiter {
+			// Showdown requires spans to be nested, so spans opened
+			// after this one need to be closed and opened again.
+			reopened := append([]string(nil), c.open[i+1:]...)
+			c.closeAfter(i)
+			c.pending = append(reopened, c.pending...)
+			return
+		}
+	}
+	c.pending = append(c.pending, delimiter)
+}
+
+// closeAfter closes spans starting from a given index in open list.
+func (c *ircConverter) closeAfter(index int) {
+	// Showdown doesn't allow spaces before closing delimiters, so
+	// those are moved after them.
+	contents := c.String()
+	trimmed := strings.TrimRightFunc(contents, unicode.IsSpace)
+	c.Truncate(len(trimmed))
+	for i := len(c.open) - 1; i >= index; i-- {
+		c.WriteString(c.open[i])
+	}
+	c.WriteString(contents[len(trimmed):])
+	c.open = c.open[:index]
+}
+
+func (c *ircConverter) closeAll() {
+	c.pending = nil
+	c.closeAfter(0)
+}
+
+// closeCode writes monospace text, if any.
+func (c *ircConverter) closeCode() {
+	if c.code == nil {
+		return
+	}
+	code := c.code.String()
+	c.code = nil
+	if strings.TrimSpace(code) == "" {
+		c.WriteString(code)
+		return
+	}
+	c.writePending()
+	// The delimiter needs to be longer than any sequence of backticks
+	// in the code.
+	longest := 0
+	for _, run := range strings.FieldsFunc(code, func(r rune) bool { return r != '`' }) {
+		if len(run) > longest {
+			longest = len(run)
+		}
+	}
+	delimiter := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	c.WriteString(delimiter + code + delimiter)
+}
@@ -0,0 +1,104 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xfix/showdown2irc/chatformat"
+	"github.com/xfix/showdown2irc/irc"
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// eventHandlers handle typed Showdown events. Other messages are handled
+// by showdownCommands. Besides J, L and c: commands, events include
+// joins and leaves in rooms that hide them (j and l) and messages
+// without a timestamp (c, which is used in battles). The user's own join
+// is skipped, as JOIN is sent together with a user list.
+func (c *connection) eventHandlers() showdown.EventHandlers {
+	return showdown.EventHandlers{
+		ChatMessage:    c.onChatMessage,
+		PrivateMessage: c.onPrivateMessage,
+		Join:           c.onJoin,
+		Leave:          c.onLeave,
+	}
+}
+
+func (c *connection) onChatMessage(event showdown.ChatMessage) {
+	author := event.Author.Name
+	room := event.Room
+	if c.isSelf(author) {
+		// Showdown sends own messages back once they get accepted,
+		// which is exactly what echo-message capability needs.
+		c.takePendingCommand(room.ID, "PRIVMSG")
+		if !c.hasCapability("echo-message") {
+			return
+		}
+	}
+	contents := event.Message
+	if strings.HasPrefix(contents, "//") {
+		// Get rid of one /
+		contents = contents[1:]
+	} else if strings.HasPrefix(contents, "/") {
+		parts := strings.SplitN(contents[1:], " ", 2)
+		command := parts[0]
+		argument := ""
+		if len(parts) == 2 {
+			argument = parts[1]
+		}
+		if callback, ok := chatMessageCallbacks[command]; ok {
+			callback(c, argument, author, room)
+			return
+		}
+	}
+	c.sendChatMessage(author, room, chatformat.ToIRC(contents))
+}
+
+func (c *connection) onPrivateMessage(event showdown.PrivateMessage) {
+	author := event.Author.Name
+	recipient := event.Recipient.Name
+	contents := event.Message
+	if strings.HasPrefix(contents, "/me ") {
+		contents = fmt.Sprintf("\x01ACTION %s\x01", chatformat.ToIRC(contents[len("/me "):]))
+	} else if !strings.HasPrefix(contents, "/") {
+		contents = chatformat.ToIRC(contents)
+	}
+	if !c.isSelf(author) {
+		c.send(escapeUserWithHost(author), "PRIVMSG", c.nickname, contents)
+	} else if strings.HasPrefix(contents, "/error ") {
+		c.sendNumericReason(irc.ErrCannotSendToChan, escapeUser(recipient), contents[len("/error "):])
+	} else if c.hasCapability("echo-message") {
+		c.send(escapeUserWithHost(author), "PRIVMSG", escapeUser(recipient), contents)
+	}
+}
+
+func (c *connection) onJoin(event showdown.Join) {
+	// The user's own JOIN is sent once a user list is received
+	if c.isSelf(event.User.Name) {
+		return
+	}
+	channel := escapeRoom(event.Room.ID)
+	c.send(escapeUserWithHost(event.User.Name), "JOIN", channel)
+	if ircRank, ok := rankMap[event.User.Rank]; ok {
+		c.sendGlobal("MODE", channel, fmt.Sprintf("+%c", ircRank), escapeUser(event.User.Name))
+	}
+}
+
+func (c *connection) onLeave(event showdown.Leave) {
+	c.send(escapeUserWithHost(event.User.Name), "PART", escapeRoom(event.Room.ID), "")
+}
@@ -0,0 +1,94 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+func listFormats(c *connection, command []string) {
+	c.sendFormats(c.showdown.Formats(), command)
+}
+
+// sendFormats lists sections of formats, or formats matching a query,
+// grouped by section.
+func (c *connection) sendFormats(formats showdown.Formats, command []string) {
+	if len(formats) == 0 {
+		c.sendGlobal("NOTICE", c.nickname, "The server didn't send a list of formats")
+		return
+	}
+	if len(command) == 0 {
+		c.sendGlobal("NOTICE", c.nickname, "Sections: "+strings.Join(formats.Sections(), ", ")+". Use /formats query to list formats")
+		return
+	}
+	query := unescapeUser(strings.Join(command, " "))
+	found := formats.Search(query)
+	if len(found) == 0 {
+		c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("No formats match %s", query))
+		return
+	}
+	var names []string
+	for i, format := range found {
+		names = append(names, format.Name)
+		if i == len(found)-1 || found[i+1].Section != format.Section {
+			c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("%s: %s", format.Section, strings.Join(names, ", ")))
+			names = nil
+		}
+	}
+}
+
+// checkFormat verifies that a format exists and allows a given usage,
+// sending a notice when it doesn't. Formats can't be checked before
+// the server sends them, so they are assumed to be correct.
+func (c *connection) checkFormat(formats showdown.Formats, name string, allowed func(showdown.Format) bool, usage string) bool {
+	if len(formats) == 0 {
+		return true
+	}
+	format, ok := formats.Find(name)
+	if !ok {
+		c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("Unknown format %s, use /formats to find formats", name))
+		return false
+	}
+	if !allowed(format) {
+		c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("%s can't be used for %s", format.Name, usage))
+		return false
+	}
+	return true
+}
+
+// formatCommand creates an IRC command like showdownCommand, which
+// validates a format given as a parameter first.
+func formatCommand(name string, formatParam int, allowed func(showdown.Format) bool, usage string) func(*connection, []string) {
+	command := showdownCommand(name, formatParam+1)
+	return func(c *connection, params []string) {
+		if len(params) > formatParam && !c.checkFormat(c.showdown.Formats(), unescapeUser(params[formatParam]), allowed, usage) {
+			return
+		}
+		command(c, params)
+	}
+}
+
+func isChallengeable(format showdown.Format) bool {
+	return format.Challengeable
+}
+
+func isSearchable(format showdown.Format) bool {
+	return format.Searchable
+}
@@ -0,0 +1,92 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// Number of messages per user and room which can be removed by
+// moderators. Showdown doesn't allow hiding more than 100 lines at once.
+const rememberedChatMessages = 100
+
+// sendChatMessage sends a chat message from an user, remembering its IDs
+// in case a moderator decides to hide it.
+func (c *connection) sendChatMessage(author string, room *showdown.Room, contents string) {
+	ids := c.sendWithID(escapeUserWithHost(author), "PRIVMSG", escapeRoom(room.ID), contents)
+
+	key := roomKey(room.ID)
+	authors := c.chatMessages[key]
+	if authors == nil {
+		authors = map[showdown.UserID][][]string{}
+		c.chatMessages[key] = authors
+	}
+	userID := showdown.ToID(author)
+	messages := append(authors[userID], ids)
+	if len(messages) > rememberedChatMessages {
+		messages = messages[len(messages)-rememberedChatMessages:]
+	}
+	authors[userID] = messages
+}
+
+// hideLines handles messages being hidden by moderators. When lines is
+// 0, all messages by an user are hidden.
+func (c *connection) hideLines(room *showdown.Room, userID showdown.UserID, lines int, deleted bool) {
+	key := roomKey(room.ID)
+	messages := c.chatMessages[key][userID]
+	hidden := messages
+	if lines > 0 && lines < len(messages) {
+		hidden = messages[len(messages)-lines:]
+	}
+	if len(hidden) == len(messages) {
+		delete(c.chatMessages[key], userID)
+	} else {
+		c.chatMessages[key][userID] = messages[:len(messages)-len(hidden)]
+	}
+
+	verb := "hidden"
+	if deleted {
+		verb = "deleted"
+	}
+	channel := escapeRoom(room.ID)
+	if c.canRedact() && len(hidden) != 0 {
+		var ids []string
+		for _, messageIDs := range hidden {
+			ids = append(ids, messageIDs...)
+		}
+		c.redact(channel, ids, fmt.Sprintf("Message %s by a moderator", verb))
+		return
+	}
+
+	name := string(userID)
+	if user, ok := room.UserList[userID]; ok {
+		name = user.Name
+	}
+	name = coloredNick(name)
+	// Only lines which were shown on IRC are counted, as the server
+	// may hide messages sent before the room was joined.
+	switch len(hidden) {
+	case 0:
+		c.sendGlobal("NOTICE", channel, fmt.Sprintf("Lines from %s were %s by a moderator", name, verb))
+	case 1:
+		c.sendGlobal("NOTICE", channel, fmt.Sprintf("1 line from %s was %s by a moderator", name, verb))
+	default:
+		c.sendGlobal("NOTICE", channel, fmt.Sprintf("%d lines from %s were %s by a moderator", len(hidden), name, verb))
+	}
+}
@@ -0,0 +1,334 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package html2irc
+
+import (
+	"fmt"
+	imagecolor "image/color"
+	"strconv"
+	"strings"
+)
+
+// color represents an optional RGB color.
+type color struct {
+	r, g, b uint8
+	set     bool
+}
+
+// IRC colors, as commonly displayed by IRC clients. The index of a color
+// is its IRC color number.
+var ircColors = []color{
+	{255, 255, 255, true}, // white
+	{0, 0, 0, true},       // black
+	{0, 0, 127, true},     // blue
+	{0, 147, 0, true},     // green
+	{255, 0, 0, true},     // red
+	{127, 0, 0, true},     // brown
+	{156, 0, 156, true},   // purple
+	{252, 127, 0, true},   // orange
+	{255, 255, 0, true},   // yellow
+	{0, 252, 0, true},     // light green
+	{0, 147, 147, true},   // cyan
+	{0, 255, 255, true},   // light cyan
+	{0, 0, 252, true},     // light blue
+	{255, 0, 255, true},   // pink
+	{127, 127, 127, true}, // grey
+	{210, 210, 210, true}, // light grey
+}
+
+// nearestIRCColor finds IRC color number that is closest to a given
+// color.
+func (c color) nearestIRCColor() int {
+	nearest := 0
+	nearestDistance := -1
+	for i, ircColor := range ircColors {
+		dr := int(c.r) - int(ircColor.r)
+		dg := int(c.g) - int(ircColor.g)
+		db := int(c.b) - int(ircColor.b)
+		// Human eye is more sensitive to green than to other colors,
+		// so use weights that approximate that.
+		distance := 3*dr*dr + 4*dg*dg + 2*db*db
+		if nearestDistance < 0 || distance < nearestDistance {
+			nearest = i
+			nearestDistance = distance
+		}
+	}
+	return nearest
+}
+
+// fromImageColor converts a color from image/color package.
+func fromImageColor(c imagecolor.Color) color {
+	r, g, b, _ := c.RGBA()
+	return color{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), true}
+}
+
+// NearestIRCColor finds IRC color number that is closest to a given
+// color.
+func NearestIRCColor(c imagecolor.Color) int {
+	return fromImageColor(c).nearestIRCColor()
+}
+
+func (c color) hex() string {
+	return fmt.Sprintf("%02X%02X%02X", c.r, c.g, c.b)
+}
+
+// colorCode creates IRC formatting code that sets foreground and
+// background colors. When hex is true, \x04 hex color code is used
+// instead of more commonly supported \x03.
+func colorCode(foreground, background color, hex bool) string {
+	// \x04 requires foreground color, so it cannot be used when
+	// only background is set.
+	if hex && foreground.set {
+		code := "\x04" + foreground.hex()
+		if background.set {
+			code += "," + background.hex()
+		}
+		return code
+	}
+	// Color numbers are always written with two digits, as otherwise
+	// following digits in text could be considered to be a part of
+	// color code.
+	code := "\x0399"
+	if foreground.set {
+		code = fmt.Sprintf("\x03%02d", foreground.nearestIRCColor())
+	}
+	if background.set {
+		code += fmt.Sprintf(",%02d", background.nearestIRCColor())
+	}
+	return code
+}
+
+// parseCSSColor parses a CSS color value, such as red, #F00, #FF0000 or
+// rgb(255, 0, 0). Transparent colors and unrecognized values are not
+// considered to be set.
+func parseCSSColor(value string) color {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if strings.HasPrefix(value, "#") {
+		hex := value[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) == 8 {
+			hex = hex[:6]
+		}
+		if len(hex) != 6 {
+			return color{}
+		}
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return color{}
+		}
+		return color{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), true}
+	}
+	if strings.HasPrefix(value, "rgb(") || strings.HasPrefix(value, "rgba(") {
+		start := strings.Index(value, "(")
+		if !strings.HasSuffix(value, ")") {
+			return color{}
+		}
+		components := strings.FieldsFunc(value[start+1:len(value)-1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '/'
+		})
+		if len(components) < 3 {
+			return color{}
+		}
+		if len(components) > 3 && strings.TrimSpace(components[3]) == "0" {
+			return color{}
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			component, ok := parseColorComponent(components[i])
+			if !ok {
+				return color{}
+			}
+			rgb[i] = component
+		}
+		return color{rgb[0], rgb[1], rgb[2], true}
+	}
+	if rgb, ok := namedColors[value]; ok {
+		return color{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), true}
+	}
+	return color{}
+}
+
+func parseColorComponent(component string) (uint8, bool) {
+	percentage := strings.HasSuffix(component, "%")
+	value, err := strconv.ParseFloat(strings.TrimSuffix(component, "%"), 64)
+	if err != nil {
+		return 0, false
+	}
+	if percentage {
+		value = value * 255 / 100
+	}
+	if value < 0 {
+		value = 0
+	} else if value > 255 {
+		value = 255
+	}
+	return uint8(value + 0.5), true
+}
+
+// Colors that can be used by name in CSS
+var namedColors = map[string]uint32{
+	"aliceblue":            0xF0F8FF,
+	"antiquewhite":         0xFAEBD7,
+	"aqua":                 0x00FFFF,
+	"aquamarine":           0x7FFFD4,
+	"azure":                0xF0FFFF,
+	"beige":                0xF5F5DC,
+	"bisque":               0xFFE4C4,
+	"black":                0x000000,
+	"blanchedalmond":       0xFFEBCD,
+	"blue":                 0x0000FF,
+	"blueviolet":           0x8A2BE2,
+	"brown":                0xA52A2A,
+	"burlywood":            0xDEB887,
+	"cadetblue":            0x5F9EA0,
+	"chartreuse":           0x7FFF00,
+	"chocolate":            0xD2691E,
+	"coral":                0xFF7F50,
+	"cornflowerblue":       0x6495ED,
+	"cornsilk":             0xFFF8DC,
+	"crimson":              0xDC143C,
+	"cyan":                 0x00FFFF,
+	"darkblue":             0x00008B,
+	"darkcyan":             0x008B8B,
+	"darkgoldenrod":        0xB8860B,
+	"darkgray":             0xA9A9A9,
+	"darkgreen":            0x006400,
+	"darkgrey":             0xA9A9A9,
+	"darkkhaki":            0xBDB76B,
+	"darkmagenta":          0x8B008B,
+	"darkolivegreen":       0x556B2F,
+	"darkorange":           0xFF8C00,
+	"darkorchid":           0x9932CC,
+	"darkred":              0x8B0000,
+	"darksalmon":           0xE9967A,
+	"darkseagreen":         0x8FBC8F,
+	"darkslateblue":        0x483D8B,
+	"darkslategray":        0x2F4F4F,
+	"darkslategrey":        0x2F4F4F,
+	"darkturquoise":        0x00CED1,
+	"darkviolet":           0x9400D3,
+	"deeppink":             0xFF1493,
+	"deepskyblue":          0x00BFFF,
+	"dimgray":              0x696969,
+	"dimgrey":              0x696969,
+	"dodgerblue":           0x1E90FF,
+	"firebrick":            0xB22222,
+	"floralwhite":          0xFFFAF0,
+	"forestgreen":          0x228B22,
+	"fuchsia":              0xFF00FF,
+	"gainsboro":            0xDCDCDC,
+	"ghostwhite":           0xF8F8FF,
+	"gold":                 0xFFD700,
+	"goldenrod":            0xDAA520,
+	"gray":                 0x808080,
+	"green":                0x008000,
+	"greenyellow":          0xADFF2F,
+	"grey":                 0x808080,
+	"honeydew":             0xF0FFF0,
+	"hotpink":              0xFF69B4,
+	"indianred":            0xCD5C5C,
+	"indigo":               0x4B0082,
+	"ivory":                0xFFFFF0,
+	"khaki":                0xF0E68C,
+	"lavender":             0xE6E6FA,
+	"lavenderblush":        0xFFF0F5,
+	"lawngreen":            0x7CFC00,
+	"lemonchiffon":         0xFFFACD,
+	"lightblue":            0xADD8E6,
+	"lightcoral":           0xF08080,
+	"lightcyan":            0xE0FFFF,
+	"lightgoldenrodyellow": 0xFAFAD2,
+	"lightgray":            0xD3D3D3,
+	"lightgreen":           0x90EE90,
+	"lightgrey":            0xD3D3D3,
+	"lightpink":            0xFFB6C1,
+	"lightsalmon":          0xFFA07A,
+	"lightseagreen":        0x20B2AA,
+	"lightskyblue":         0x87CEFA,
+	"lightslategray":       0x778899,
+	"lightslategrey":       0x778899,
+	"lightsteelblue":       0xB0C4DE,
+	"lightyellow":          0xFFFFE0,
+	"lime":                 0x00FF00,
+	"limegreen":            0x32CD32,
+	"linen":                0xFAF0E6,
+	"magenta":              0xFF00FF,
+	"maroon":               0x800000,
+	"mediumaquamarine":     0x66CDAA,
+	"mediumblue":           0x0000CD,
+	"mediumorchid":         0xBA55D3,
+	"mediumpurple":         0x9370DB,
+	"mediumseagreen":       0x3CB371,
+	"mediumslateblue":      0x7B68EE,
+	"mediumspringgreen":    0x00FA9A,
+	"mediumturquoise":      0x48D1CC,
+	"mediumvioletred":      0xC71585,
+	"midnightblue":         0x191970,
+	"mintcream":            0xF5FFFA,
+	"mistyrose":            0xFFE4E1,
+	"moccasin":             0xFFE4B5,
+	"navajowhite":          0xFFDEAD,
+	"navy":                 0x000080,
+	"oldlace":              0xFDF5E6,
+	"olive":                0x808000,
+	"olivedrab":            0x6B8E23,
+	"orange":               0xFFA500,
+	"orangered":            0xFF4500,
+	"orchid":               0xDA70D6,
+	"palegoldenrod":        0xEEE8AA,
+	"palegreen":            0x98FB98,
+	"paleturquoise":        0xAFEEEE,
+	"palevioletred":        0xDB7093,
+	"papayawhip":           0xFFEFD5,
+	"peachpuff":            0xFFDAB9,
+	"peru":                 0xCD853F,
+	"pink":                 0xFFC0CB,
+	"plum":                 0xDDA0DD,
+	"powderblue":           0xB0E0E6,
+	"purple":               0x800080,
+	"rebeccapurple":        0x663399,
+	"red":                  0xFF0000,
+	"rosybrown":            0xBC8F8F,
+	"royalblue":            0x4169E1,
+	"saddlebrown":          0x8B4513,
+	"salmon":               0xFA8072,
+	"sandybrown":           0xF4A460,
+	"seagreen":             0x2E8B57,
+	"seashell":             0xFFF5EE,
+	"sienna":               0xA0522D,
+	"silver":               0xC0C0C0,
+	"skyblue":              0x87CEEB,
+	"slateblue":            0x6A5ACD,
+	"slategray":            0x708090,
+	"slategrey":            0x708090,
+	"snow":                 0xFFFAFA,
+	"springgreen":          0x00FF7F,
+	"steelblue":            0x4682B4,
+	"tan":                  0xD2B48C,
+	"teal":                 0x008080,
+	"thistle":              0xD8BFD8,
+	"tomato":               0xFF6347,
+	"turquoise":            0x40E0D0,
+	"violet":               0xEE82EE,
+	"wheat":                0xF5DEB3,
+	"white":                0xFFFFFF,
+	"whitesmoke":           0xF5F5F5,
+	"yellow":               0xFFFF00,
+	"yellowgreen":          0x9ACD32,
+}
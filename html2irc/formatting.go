@@ -0,0 +1,138 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package html2irc
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// formatting is a set of IRC formatting styles that are toggled by
+// a single formatting character.
+type formatting uint8
+
+const (
+	bold formatting = 1 << iota
+	italic
+	underline
+	strikethrough
+	monospace
+)
+
+var formattingCharacters = []struct {
+	style     formatting
+	character byte
+}{
+	{bold, '\x02'},
+	{italic, '\x1D'},
+	{underline, '\x1F'},
+	{strikethrough, '\x1E'},
+	{monospace, '\x11'},
+}
+
+// codes returns formatting characters that toggle styles in a set.
+func (f formatting) codes() string {
+	var buffer bytes.Buffer
+	for _, style := range formattingCharacters {
+		if f&style.style != 0 {
+			buffer.WriteByte(style.character)
+		}
+	}
+	return buffer.String()
+}
+
+// Formatting characters which when written twice do nothing
+var emptyFormattingReplacer = strings.NewReplacer(
+	"\x02\x02", "",
+	"\x1D\x1D", "",
+	"\x1F\x1F", "",
+	"\x1E\x1E", "",
+	"\x11\x11", "",
+)
+
+// Elements that are displayed with a given style by default
+var elementFormatting = map[string]formatting{
+	"b":      bold,
+	"strong": bold,
+	"h1":     bold,
+	"h2":     bold,
+	"h3":     bold,
+	"h4":     bold,
+	"h5":     bold,
+	"h6":     bold,
+	"th":     bold,
+	"i":      italic,
+	"em":     italic,
+	"cite":   italic,
+	"dfn":    italic,
+	"var":    italic,
+	"u":      underline,
+	"ins":    underline,
+	"s":      strikethrough,
+	"strike": strikethrough,
+	"del":    strikethrough,
+	"code":   monospace,
+	"kbd":    monospace,
+	"samp":   monospace,
+	"tt":     monospace,
+	"pre":    monospace,
+}
+
+// cssFormatting changes formatting according to CSS properties. It
+// returns styles to enable and disable.
+func cssFormatting(property, argument string) (enable, disable formatting) {
+	switch property {
+	case "font-weight":
+		switch argument {
+		case "bold", "bolder":
+			return bold, 0
+		case "normal", "lighter":
+			return 0, bold
+		}
+		if weight, err := strconv.Atoi(argument); err == nil {
+			if weight >= 600 {
+				return bold, 0
+			}
+			return 0, bold
+		}
+	case "font-style":
+		switch argument {
+		case "italic", "oblique":
+			return italic, 0
+		case "normal":
+			return 0, italic
+		}
+	case "font-family":
+		if strings.Contains(argument, "monospace") {
+			return monospace, 0
+		}
+	case "text-decoration", "text-decoration-line":
+		if argument == "none" {
+			return 0, underline | strikethrough
+		}
+		for _, value := range strings.Fields(argument) {
+			switch value {
+			case "underline":
+				enable |= underline
+			case "line-through":
+				enable |= strikethrough
+			}
+		}
+	}
+	return
+}
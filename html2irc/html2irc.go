@@ -19,18 +19,36 @@ package html2irc
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"golang.org/x/net/html"
 )
 
-const boldCharacter = "\x02"
+const resetCharacter = "\x0F"
+
+// A single IRC formatting code
+const formattingCode = `[\x02\x0F\x11\x16\x1D\x1E\x1F]|\x03(?:\d\d(?:,\d\d)?)?|\x04(?:[0-9A-F]{6}(?:,[0-9A-F]{6})?)?`
+
+var (
+	formattingRegexp         = regexp.MustCompile(formattingCode)
+	trailingFormattingRegexp = regexp.MustCompile(`(?:\s|` + formattingCode + `)+$`)
+	trailingNewLinesRegexp   = regexp.MustCompile(`(?:\n(?:` + formattingCode + `)*)+$`)
+)
 
 type htmlConverter struct {
 	*bytes.Buffer
 	*html.Tokenizer
 	preventNewLines *bool
-	bold, hidden    bool
+	options         Options
+	hidden          bool
+	// Inside of collapsed <details>, but not its <summary>
+	collapsed bool
+	// Inline converters render everything in a single line, used for
+	// table cells.
+	inline                 bool
+	formatting             formatting
+	foreground, background color
 }
 
 // HTMLToIRC converts HTML code into IRC text.
@@ -38,35 +56,27 @@ type htmlConverter struct {
 // IRC text is different to regular text in that it can use IRC formatting
 // sequences such as bold.
 func HTMLToIRC(code string) []string {
-	converter := htmlConverter{
-		Buffer:          new(bytes.Buffer),
-		Tokenizer:       html.NewTokenizer(strings.NewReader(code)),
-		preventNewLines: new(bool),
-	}
-	converter.parseToken()
-	var result []string
-	for _, line := range strings.Split(converter.String(), "\n") {
-		trimmedLine := strings.Replace(
-			strings.TrimRight(strings.TrimSpace(line), boldCharacter), boldCharacter+boldCharacter, "", -1)
-
-		if !isEmpty(trimmedLine) {
-			result = append(result, trimmedLine)
-		}
-	}
-	return result
+	return Convert(code, Options{})
+}
+
+// HTMLToIRCWithHexColors converts HTML code into IRC text, using \x04
+// formatting code for colors. This allows exact colors to be used, but
+// isn't supported by most IRC clients.
+func HTMLToIRCWithHexColors(code string) []string {
+	return Convert(code, Options{Colors: HexColors})
 }
 
 func (c htmlConverter) parseToken() {
 	for {
 		tt := c.Tokenizer.Next()
 		switch tt {
-		case html.StartTagToken:
-			c.parseStartToken()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			c.parseStartToken(tt == html.SelfClosingTagToken)
 
 		case html.TextToken:
-			if !c.hidden {
+			if !c.isHidden() {
 				*c.preventNewLines = false
-				c.Write(c.Text())
+				c.writeText(string(c.Text()))
 			}
 
 		case html.EndTagToken, html.ErrorToken:
@@ -75,34 +85,95 @@ func (c htmlConverter) parseToken() {
 	}
 }
 
+func (c htmlConverter) isHidden() bool {
+	return c.hidden || c.collapsed
+}
+
+// writeText writes text, restoring formatting after new lines.
+func (c htmlConverter) writeText(text string) {
+	if c.inline {
+		c.WriteString(strings.Replace(text, "\n", " ", -1))
+		return
+	}
+	lines := strings.Split(text, "\n")
+	c.WriteString(lines[0])
+	for _, line := range lines[1:] {
+		c.WriteByte('\n')
+		c.restoreFormatting()
+		c.WriteString(line)
+	}
+}
+
 func (c htmlConverter) printNewline() {
 	if *c.preventNewLines {
 		return
 	}
+	if c.inline {
+		c.WriteByte(' ')
+		return
+	}
 	c.WriteByte('\n')
-	if c.bold {
-		c.WriteByte(boldCharacter[0])
+	c.restoreFormatting()
+}
+
+// restoreFormatting writes formatting codes for current state. This is
+// needed after new lines, as IRC formatting only applies to a single
+// line.
+func (c htmlConverter) restoreFormatting() {
+	c.WriteString(c.formattingState())
+}
+
+// formattingState returns formatting codes needed to format text
+// according to current state.
+func (c htmlConverter) formattingState() string {
+	state := c.formatting.codes()
+	if c.foreground.set || c.background.set {
+		state += colorCode(c.foreground, c.background, c.options.Colors == HexColors)
+	}
+	return state
+}
+
+// restoreColors restores colors after an element with a color ends.
+func (c htmlConverter) restoreColors() {
+	if c.foreground.set || c.background.set {
+		c.WriteString(colorCode(c.foreground, c.background, c.options.Colors == HexColors))
+	} else {
+		// There is no formatting code to only remove colors, so remove
+		// all formatting, and restore formatting that isn't colors.
+		c.WriteString(resetCharacter)
+		c.restoreFormatting()
 	}
 }
 
 func (c htmlConverter) chopNewLines() string {
 	bytes := c.Bytes()
-	i := len(bytes) - 1
-	for i >= 0 && isNewLineCharacter(bytes[i]) {
-		i--
+	i := len(bytes)
+	if location := trailingNewLinesRegexp.FindIndex(bytes); location != nil {
+		i = location[0]
 	}
-	i++
 	out := string(bytes[i:])
 	c.Truncate(i)
 	return out
 }
 
+func (c htmlConverter) writeLinkStart() {
+	if c.options.LinkStyle == MarkdownLinks {
+		c.WriteByte('[')
+	}
+}
+
 func (c htmlConverter) writeLink(link string) {
 	newLines := c.chopNewLines()
-	c.WriteString(fmt.Sprintf("](%s)%s", link, newLines))
+	switch c.options.LinkStyle {
+	case MarkdownLinks:
+		c.WriteString(fmt.Sprintf("](%s)", link))
+	case AngleBracketLinks:
+		c.WriteString(fmt.Sprintf(" <%s>", link))
+	}
+	c.WriteString(newLines)
 }
 
-func (c htmlConverter) parseStartToken() {
+func (c htmlConverter) parseStartToken(selfClosing bool) {
 	// This is a really basic HTML rendering engine for purpose of decoding
 	// raw text (such as output from commands).
 	//
@@ -124,22 +195,33 @@ func (c htmlConverter) parseStartToken() {
 	// many new lines which are dealt with by removing empty lines on
 	// output.
 	//
-	// Certain elements such as <b> can be represented using IRC special
-	// formatting characters. They are dealt by preserving a state that
-	// is also used by recursive function calls (in order to prevent
+	// Certain elements such as <b> or <i> can be represented using IRC
+	// special formatting characters. They are dealt by preserving a state
+	// that is also used by recursive function calls (in order to prevent
 	// input like <b><b>hi</b></b> from returning \x02\x02hi\x02\x02,
-	// which wouldn't be bolded at all.
+	// which wouldn't be bolded at all. IRC formatting characters toggle
+	// a style, so the characters written are styles that differ between
+	// an element and its parent, and writing them again restores the
+	// formatting of a parent.
+	//
+	// Colors work similarly, with state being used to restore colors
+	// of a parent element once an element with different colors ends.
 	rawName, hasAttrs := c.TagName()
 	name := string(rawName)
 
-	var bold, block bool
+	parent := c
+	var block bool
 	var link *string
+	var foreground, background color
+	var enableStyles, disableStyles formatting
+	attributes := map[string]string{}
 
 	for hasAttrs {
 		var rawKey, rawValue []byte
 		rawKey, rawValue, hasAttrs = c.TagAttr()
 		key := string(rawKey)
 		value := string(rawValue)
+		attributes[key] = value
 
 		switch key {
 		case "value":
@@ -147,7 +229,9 @@ func (c htmlConverter) parseStartToken() {
 			case "button":
 				link = &value
 			case "input":
-				c.WriteString(value)
+				if !c.isHidden() {
+					c.WriteString(value)
+				}
 			}
 
 		case "href":
@@ -156,10 +240,15 @@ func (c htmlConverter) parseStartToken() {
 			}
 
 		case "alt":
-			if name == "img" {
+			if name == "img" && !c.isHidden() {
 				c.WriteString(value)
 			}
 
+		case "color":
+			if name == "font" {
+				foreground = parseCSSColor(value)
+			}
+
 		// I need a better CSS parser, but that will do for now
 		case "style":
 			for _, rawPair := range strings.Split(value, ";") {
@@ -170,7 +259,23 @@ func (c htmlConverter) parseStartToken() {
 				property := strings.ToLower(strings.TrimSpace(pair[0]))
 				argument := strings.ToLower(strings.TrimSpace(pair[1]))
 
+				enable, disable := cssFormatting(property, argument)
+				enableStyles |= enable
+				disableStyles |= disable
+
 				switch property {
+				case "color":
+					foreground = parseCSSColor(argument)
+				case "background-color":
+					background = parseCSSColor(argument)
+				case "background":
+					// Background shorthand can contain things other
+					// than a color, like images.
+					for _, value := range strings.Fields(argument) {
+						if background = parseCSSColor(value); background.set {
+							break
+						}
+					}
 				case "display":
 					switch argument {
 					case "hidden":
@@ -184,55 +289,100 @@ func (c htmlConverter) parseStartToken() {
 	}
 
 	switch name {
-	case "b", "strong":
-		if !c.bold {
-			bold = true
-			c.bold = true
-		}
-	case "p", "td", "center", "div", "ol":
-		block = true
-	case "h1", "h2", "h3":
-		if !c.bold {
-			bold = true
-			c.bold = true
-		}
+	case "p", "td", "center", "div", "ol", "pre", "table",
+		"h1", "h2", "h3", "h4", "h5", "h6":
 		block = true
 	case "br", "hr":
 		c.printNewline()
 		return
 	case "li":
 		c.printNewline()
-		c.WriteString("• ")
+		if !c.isHidden() {
+			c.WriteString("• ")
+		}
 	case "img", "input":
 		return
+	case "details":
+		block = true
+		if _, open := attributes["open"]; !open {
+			c.collapsed = true
+		}
+	case "summary":
+		c.printNewline()
+		defer c.printNewline()
+		if !c.isHidden() {
+			c.WriteString(expandedMarker)
+		} else if c.collapsed {
+			c.collapsed = false
+			if !c.hidden {
+				c.WriteString(collapsedMarker)
+			}
+		}
+	case "psicon":
+		if !c.isHidden() {
+			c.WriteString(iconText(attributes))
+		}
+		if !selfClosing {
+			c.hidden = true
+			c.parseToken()
+		}
+		return
+	case "time":
+		c.parseTime(attributes["datetime"], selfClosing)
+		return
+	case "username":
+		c.parseUsername(attributes["name"], selfClosing)
+		return
+	case "youtube", "spotify", "twitch":
+		if src := attributes["src"]; src != "" {
+			link = &src
+		}
 	}
 
-	if bold {
-		c.WriteByte(boldCharacter[0])
-		defer c.WriteByte(boldCharacter[0])
+	if (foreground.set || background.set) && c.options.Colors != NoColors {
+		if foreground.set {
+			c.foreground = foreground
+		}
+		if background.set {
+			c.background = background
+		}
+		if c.foreground != parent.foreground || c.background != parent.background {
+			c.WriteString(colorCode(c.foreground, c.background, c.options.Colors == HexColors))
+			// Registered before formatting, so it would run after it.
+			// This is necessary, as restoring colors may reset
+			// formatting.
+			defer parent.restoreColors()
+		}
+	}
+	// CSS has priority over default element formatting
+	c.formatting = (c.formatting | elementFormatting[name] | enableStyles) &^ disableStyles
+	if changed := c.formatting ^ parent.formatting; changed != 0 {
+		c.WriteString(changed.codes())
+		defer c.WriteString(changed.codes())
 	}
 	if block {
 		c.printNewline()
 		defer c.printNewline()
 	}
-	if link != nil {
-		c.WriteByte('[')
+	if link != nil && !c.isHidden() {
+		c.writeLinkStart()
 		*c.preventNewLines = true
 		defer c.writeLink(*link)
+		if embedName, ok := embedNames[name]; ok {
+			c.WriteString(embedName)
+		}
 	}
 
-	c.parseToken()
-}
-
-func isNewLineCharacter(b byte) bool {
-	return b == '\n' || b == boldCharacter[0]
+	if selfClosing {
+		return
+	}
+	if name == "table" {
+		c.parseTable()
+	} else {
+		c.parseToken()
+	}
 }
 
 func isEmpty(line string) bool {
-	for _, char := range []byte(line) {
-		if !isNewLineCharacter(char) {
-			return false
-		}
-	}
-	return true
+	return strings.TrimSpace(formattingRegexp.ReplaceAllString(line, "")) == ""
 }
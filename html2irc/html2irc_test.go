@@ -3,6 +3,7 @@ package html2irc
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -24,12 +25,81 @@ var tests = []struct {
 	{"<span style='display; display:;'>a</span>", []string{"a"}},
 	{"<ul><li>a<li>b<li>c</ul>", []string{"• a", "• b", "• c"}},
 	{"yes<h1>Heading</h1>no", []string{"yes", "\x02Heading", "no"}},
+	{"<font color='red'>a</font>b", []string{"\x0304a\x0Fb"}},
+	{"<span style='color: #00F'>1</span>2", []string{"\x0312" + "1\x0F2"}},
+	{"<span style='color: rgb(0, 147, 0)'>a<b style='color: black'>b</b>c</span>", []string{"\x0303a\x0301\x02b\x02\x0303c"}},
+	{"<b>a<span style='background: url(x.png) yellow'>b</span>c</b>", []string{"\x02a\x0399,08b\x0F\x02c"}},
+	{"<div style='color: red'><div>a</div><div>b</div></div>", []string{"\x0304a", "\x0304b"}},
+	{"<span style='color: transparent; background-color: inherit'>a</span>", []string{"a"}},
+	{"<i>a<em>b</em></i><u>c</u><s>d</s><code>e</code>", []string{"\x1Dab\x1D\x1Fc\x1F\x1Ed\x1E\x11e"}},
+	{"<b>a<i>b</i>c</b>", []string{"\x02a\x1Db\x1Dc"}},
+	{"<b>a<span style='font-weight: normal'>b</span>c</b>", []string{"\x02a\x02b\x02c"}},
+	{"<span style='font-weight: 700; font-style: italic; text-decoration: underline line-through'>a</span>", []string{"\x02\x1D\x1F\x1Ea"}},
+	{"<u>a<span style='text-decoration: none'>b</span></u>", []string{"\x1Fa\x1Fb"}},
+	{"<i><div>a</div><div>b</div></i>", []string{"\x1Da", "\x1Db"}},
+	{"<pre>a\nb</pre>", []string{"\x11a", "\x11b"}},
+	{"<table><tr><th>Name</th><th>Elo</th></tr><tr><td>Alice</td><td>1500</td></tr><tr><td>Bob</td><td><b>999</b></td></tr></table>",
+		[]string{"\x02Name\x02  | \x02Elo", "------+-----", "Alice | 1500", "Bob   | \x02999"}},
+	{"<table><tr><td colspan='2'>Long heading cell</td></tr><tr><td>a</td><td>b</td></tr></table>",
+		[]string{"Long heading cell", "a | b"}},
+	{"<table><tr><td colspan='2'>Heading</td></tr><tr><td>long</td><td>cells</td></tr></table>",
+		[]string{"Heading", "long | cells"}},
+	{"a<table><tr><td><div>multi</div><div>line</div></td><td>x</td></tr></table>b",
+		[]string{"a", "multi line | x", "b"}},
+	{"<table><tr><td>" + strings.Repeat("a", 50) + "</td><td>" + strings.Repeat("b", 50) + "</td></tr><tr><td>c</td><td>d</td></tr></table>",
+		[]string{strings.Repeat("a", 50) + " | " + strings.Repeat("b", 50), "c | d"}},
+	{"Hi <username name='Zarel'>Zarel</username>!", []string{"Hi \x02\x0310Zarel\x02\x0F!"}},
+	{"<b><username name='Zarel'/></b>", []string{"\x02\x0310Zarel"}},
+	{"<psicon pokemon='pikachu'></psicon> and <psicon item='leftovers' />", []string{"[Pikachu] and [Leftovers]"}},
+	{"<details><summary>More</summary>hidden<ul><li>item</li></ul><a href='x'>link</a></details>after", []string{"▸ More", "after"}},
+	{"<details open><summary>More</summary>shown</details>", []string{"▾ More", "shown"}},
+	{"<youtube src='https://www.youtube.com/embed/abc'></youtube>", []string{"[YouTube video](https://www.youtube.com/embed/abc)"}},
+	{"a<br/>b", []string{"a", "b"}},
+	{"<time>not a time</time>", []string{"not a time"}},
 	{"", nil},
 	{strings.Repeat("<div>", 10000), nil},
 }
 
+func TestHTMLToIRCWithHexColors(t *testing.T) {
+	in := "<font color='#123456'>a</font><span style='background: red'>b</span>"
+	out := []string{"\x04123456a\x0F\x0399,04b"}
+	assert.Equal(t, HTMLToIRCWithHexColors(in), out, "HTMLToIRCWithHexColors(%#q)", in)
+}
+
 func TestHTMLToIRC(t *testing.T) {
 	for _, test := range tests {
 		assert.Equal(t, HTMLToIRC(test.in), test.out, "HTMLToIRC(%#q)", test.in)
 	}
 }
+
+func TestTime(t *testing.T) {
+	timeLocation = time.UTC
+	defer func() { timeLocation = time.Local }()
+	for _, in := range []string{"<time>2016-04-01T12:30:00+02:00</time>", "<time datetime='2016-04-01T10:30:00Z'/>"} {
+		assert.Equal(t, HTMLToIRC(in), []string{"Fri, 1 Apr 2016 10:30 UTC"}, "HTMLToIRC(%#q)", in)
+	}
+}
+
+var rendererTests = []struct {
+	options Options
+	out     []string
+}{
+	{Options{Renderer: PlainRenderer{}}, []string{"Hello Zarel: see [docs](http://x)", "a | b"}},
+	{Options{Renderer: MarkdownRenderer{}}, []string{"**Hello Zarel**: see [*docs*](http://x)", "a | b"}},
+	{Options{Renderer: ANSIRenderer{}, LinkStyle: AngleBracketLinks}, []string{"\x1b[0;1mHello\x1b[0m \x1b[0;1;36mZarel\x1b[0m: see \x1b[0;3mdocs\x1b[0m <http://x>", "a | b"}},
+	{Options{Colors: NoColors, LinkStyle: TextOnlyLinks}, []string{"\x02Hello\x02 \x02Zarel\x02: see \x1Ddocs", "a | b"}},
+	{Options{Renderer: PlainRenderer{}, MaxWidth: 10}, []string{"Hello", "Zarel: see", "[docs](htt", "p://x)", "a | b"}},
+}
+
+func TestRenderers(t *testing.T) {
+	in := "<b>Hello</b> <username>Zarel</username>: see <a href='http://x'><i>docs</i></a><table><tr><td>a</td><td>b</td></tr></table>"
+	for _, test := range rendererTests {
+		assert.Equal(t, Convert(in, test.options), test.out, "Convert(%#q, %+v)", in, test.options)
+	}
+}
+
+func TestMarkdownRenderer(t *testing.T) {
+	in := "\x02bold \x1Dboth\x02 italic\x1D \x11a*b`c\x11"
+	out := "**bold *both*** *italic* ``a*b`c``"
+	assert.Equal(t, MarkdownRenderer{}.Render(in), out, "MarkdownRenderer.Render(%#q)", in)
+}
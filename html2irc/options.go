@@ -0,0 +1,94 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package html2irc
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/xfix/showdown2irc/irc"
+	"golang.org/x/net/html"
+)
+
+// LinkStyle specifies how links are displayed.
+type LinkStyle int
+
+const (
+	// MarkdownLinks displays links as [text](url).
+	MarkdownLinks LinkStyle = iota
+	// AngleBracketLinks displays links as text <url>.
+	AngleBracketLinks
+	// TextOnlyLinks displays only the text of links.
+	TextOnlyLinks
+)
+
+// ColorMode specifies how colors are displayed.
+type ColorMode int
+
+const (
+	// PaletteColors uses the nearest of 16 standard IRC colors.
+	PaletteColors ColorMode = iota
+	// HexColors uses exact colors, with \x04 formatting code. Most IRC
+	// clients don't support it.
+	HexColors
+	// NoColors ignores colors.
+	NoColors
+)
+
+// Options control how HTML is converted. The zero value converts HTML
+// into IRC text, as done by HTMLToIRC.
+type Options struct {
+	// Renderer used for output, IRCRenderer when nil
+	Renderer  Renderer
+	LinkStyle LinkStyle
+	Colors    ColorMode
+	// Maximum number of characters in a line, or 0 for no limit. Longer
+	// lines are wrapped on spaces when possible.
+	MaxWidth int
+}
+
+// Convert converts HTML code into lines of text, according to options.
+func Convert(code string, options Options) []string {
+	converter := htmlConverter{
+		Buffer:          new(bytes.Buffer),
+		Tokenizer:       html.NewTokenizer(strings.NewReader(code)),
+		preventNewLines: new(bool),
+		options:         options,
+	}
+	converter.parseToken()
+	renderer := options.Renderer
+	if renderer == nil {
+		renderer = IRCRenderer{}
+	}
+	var result []string
+	for _, line := range strings.Split(converter.String(), "\n") {
+		trimmedLine := emptyFormattingReplacer.Replace(
+			trailingFormattingRegexp.ReplaceAllString(strings.TrimSpace(line), ""))
+
+		if isEmpty(trimmedLine) {
+			continue
+		}
+		wrapped := []string{trimmedLine}
+		if options.MaxWidth > 0 {
+			wrapped = irc.WrapText(trimmedLine, options.MaxWidth)
+		}
+		for _, part := range wrapped {
+			result = append(result, renderer.Render(part))
+		}
+	}
+	return result
+}
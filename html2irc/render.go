@@ -0,0 +1,314 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package html2irc
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Renderer produces output of a converter. HTML is always converted
+// into IRC text first, and renderers convert each line of it into their
+// output format.
+type Renderer interface {
+	Render(line string) string
+}
+
+// IRCRenderer outputs IRC text, with IRC formatting codes.
+type IRCRenderer struct{}
+
+// Render returns a line as it is.
+func (IRCRenderer) Render(line string) string {
+	return line
+}
+
+// PlainRenderer outputs text without any formatting.
+type PlainRenderer struct{}
+
+// Render removes formatting codes from a line.
+func (PlainRenderer) Render(line string) string {
+	var buffer bytes.Buffer
+	for _, segment := range parseSegments(line) {
+		buffer.WriteString(segment.text)
+	}
+	return buffer.String()
+}
+
+// ANSIRenderer outputs text with ANSI escape sequences, as understood
+// by terminals. Exact colors use 24-bit color sequences.
+type ANSIRenderer struct{}
+
+// Render converts formatting codes in a line into ANSI escape sequences.
+func (ANSIRenderer) Render(line string) string {
+	var buffer bytes.Buffer
+	var current textStyle
+	for _, segment := range parseSegments(line) {
+		if segment.style != current {
+			buffer.WriteString(segment.style.ansi())
+			current = segment.style
+		}
+		buffer.WriteString(segment.text)
+	}
+	if current != (textStyle{}) {
+		buffer.WriteString("\x1b[0m")
+	}
+	return buffer.String()
+}
+
+// MarkdownRenderer outputs Markdown. Styles not supported by Markdown,
+// like colors and underline, are ignored.
+type MarkdownRenderer struct{}
+
+// Styles that Markdown supports, with their delimiters
+var markdownDelimiters = []struct {
+	style     formatting
+	delimiter string
+}{
+	{bold, "**"},
+	{italic, "*"},
+	{strikethrough, "~~"},
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`")
+
+// Render converts formatting codes in a line into Markdown.
+func (MarkdownRenderer) Render(line string) string {
+	var buffer bytes.Buffer
+	var open []formatting
+	// Markdown doesn't allow spaces next to delimiters, so spaces are
+	// written after delimiters are closed and before they are opened.
+	pendingSpace := ""
+	closeAfter := func(index int) {
+		for i := len(open) - 1; i >= index; i-- {
+			buffer.WriteString(markdownDelimiter(open[i]))
+		}
+		open = open[:index]
+	}
+
+	for _, segment := range mergeMarkdownSegments(parseSegments(line)) {
+		core := strings.TrimLeftFunc(segment.text, unicode.IsSpace)
+		leading := segment.text[:len(segment.text)-len(core)]
+		trimmed := strings.TrimRightFunc(core, unicode.IsSpace)
+		trailing := core[len(trimmed):]
+		if trimmed == "" {
+			pendingSpace += segment.text
+			continue
+		}
+
+		style := segment.style.formatting
+		for i, span := range open {
+			if style&span == 0 {
+				closeAfter(i)
+				break
+			}
+		}
+		buffer.WriteString(pendingSpace + leading)
+		for _, span := range markdownDelimiters {
+			if style&span.style != 0 && !containsStyle(open, span.style) {
+				buffer.WriteString(span.delimiter)
+				open = append(open, span.style)
+			}
+		}
+		if style&monospace != 0 {
+			buffer.WriteString(markdownCode(trimmed))
+		} else {
+			buffer.WriteString(markdownEscaper.Replace(trimmed))
+		}
+		pendingSpace = trailing
+	}
+	closeAfter(0)
+	buffer.WriteString(pendingSpace)
+	return buffer.String()
+}
+
+// mergeMarkdownSegments joins segments that only differ in styles that
+// Markdown doesn't display.
+func mergeMarkdownSegments(segments []textSegment) []textSegment {
+	var merged []textSegment
+	for _, segment := range segments {
+		segment.style = textStyle{formatting: segment.style.formatting &^ underline}
+		if last := len(merged) - 1; last >= 0 && merged[last].style == segment.style {
+			merged[last].text += segment.text
+		} else {
+			merged = append(merged, segment)
+		}
+	}
+	return merged
+}
+
+func markdownDelimiter(style formatting) string {
+	for _, span := range markdownDelimiters {
+		if span.style == style {
+			return span.delimiter
+		}
+	}
+	return ""
+}
+
+func containsStyle(styles []formatting, style formatting) bool {
+	for _, s := range styles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
+
+// markdownCode writes code, using a delimiter longer than any sequence
+// of backticks in the code.
+func markdownCode(code string) string {
+	longest := 0
+	for _, run := range strings.FieldsFunc(code, func(r rune) bool { return r != '`' }) {
+		if len(run) > longest {
+			longest = len(run)
+		}
+	}
+	delimiter := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return delimiter + code + delimiter
+}
+
+// textStyle describes a style of text in IRC line.
+type textStyle struct {
+	formatting formatting
+	// IRC color numbers, or -1 for exact colors
+	foregroundIndex, backgroundIndex int
+	foreground, background           color
+}
+
+// ansi returns an ANSI escape sequence that sets the style.
+func (s textStyle) ansi() string {
+	codes := []string{"0"}
+	for _, style := range []struct {
+		style formatting
+		code  string
+	}{{bold, "1"}, {italic, "3"}, {underline, "4"}, {strikethrough, "9"}} {
+		if s.formatting&style.style != 0 {
+			codes = append(codes, style.code)
+		}
+	}
+	if s.foreground.set {
+		codes = append(codes, ansiColor(s.foregroundIndex, s.foreground, 0))
+	}
+	if s.background.set {
+		codes = append(codes, ansiColor(s.backgroundIndex, s.background, 10))
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// ANSI color codes for IRC colors, for foreground
+var ansiColors = []int{97, 30, 34, 32, 91, 31, 35, 33, 93, 92, 36, 96, 94, 95, 90, 37}
+
+func ansiColor(index int, c color, offset int) string {
+	if index >= 0 {
+		return strconv.Itoa(ansiColors[index] + offset)
+	}
+	return fmt.Sprintf("%d;2;%d;%d;%d", 38+offset, c.r, c.g, c.b)
+}
+
+type textSegment struct {
+	text  string
+	style textStyle
+}
+
+var (
+	segmentColorRegexp    = regexp.MustCompile(`^\x03(?:(\d{1,2})(?:,(\d{1,2}))?)?`)
+	segmentHexColorRegexp = regexp.MustCompile(`^\x04(?:([0-9A-Fa-f]{6})(?:,([0-9A-Fa-f]{6}))?)?`)
+)
+
+// parseSegments splits IRC text into parts with the same style.
+func parseSegments(line string) []textSegment {
+	var segments []textSegment
+	var style textStyle
+	var text bytes.Buffer
+	flush := func() {
+		if text.Len() != 0 {
+			segments = append(segments, textSegment{text.String(), style})
+			text.Reset()
+		}
+	}
+	for i := 0; i < len(line); {
+		character := line[i]
+		switch {
+		case character == resetCharacter[0]:
+			flush()
+			style = textStyle{}
+			i++
+		case character == '\x03':
+			flush()
+			match := segmentColorRegexp.FindStringSubmatch(line[i:])
+			style.setPaletteColors(match[1], match[2])
+			i += len(match[0])
+		case character == '\x04':
+			flush()
+			match := segmentHexColorRegexp.FindStringSubmatch(line[i:])
+			style.setHexColors(match[1], match[2])
+			i += len(match[0])
+		case character < ' ':
+			flush()
+			for _, code := range formattingCharacters {
+				if code.character == character {
+					style.formatting ^= code.style
+				}
+			}
+			i++
+		default:
+			text.WriteByte(character)
+			i++
+		}
+	}
+	flush()
+	return segments
+}
+
+func (s *textStyle) setPaletteColors(foreground, background string) {
+	if foreground == "" {
+		*s = textStyle{formatting: s.formatting}
+		return
+	}
+	s.foregroundIndex, s.foreground = paletteColor(foreground)
+	if background != "" {
+		s.backgroundIndex, s.background = paletteColor(background)
+	}
+}
+
+// paletteColor parses IRC color number. Numbers that aren't standard
+// colors, like 99, mean the default color.
+func paletteColor(number string) (int, color) {
+	index, _ := strconv.Atoi(number)
+	if index >= len(ircColors) {
+		return 0, color{}
+	}
+	return index, ircColors[index]
+}
+
+func (s *textStyle) setHexColors(foreground, background string) {
+	if foreground == "" {
+		*s = textStyle{formatting: s.formatting}
+		return
+	}
+	s.foregroundIndex, s.foreground = -1, parseCSSColor("#"+foreground)
+	if background != "" {
+		s.backgroundIndex, s.background = -1, parseCSSColor("#"+background)
+	}
+}
@@ -0,0 +1,128 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package html2irc
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// Showdown uses custom elements in HTML, which are rendered by its
+// client. Those are handled here.
+
+// Location in which <time> elements are displayed
+var timeLocation = time.Local
+
+const timeLayout = "Mon, 2 Jan 2006 15:04 MST"
+
+// Attributes of <psicon> which specify an icon
+var iconAttributes = []string{"pokemon", "item", "type", "category"}
+
+// Names of services whose embeds are shown as links
+var embedNames = map[string]string{
+	"youtube": "YouTube video",
+	"spotify": "Spotify song",
+	"twitch":  "Twitch stream",
+}
+
+// Markers displayed before a summary of <details>, depending on whether
+// contents are shown.
+const (
+	collapsedMarker = "▸ "
+	expandedMarker  = "▾ "
+)
+
+// iconText describes an icon, like [Pikachu], given attributes of
+// <psicon> element.
+func iconText(attributes map[string]string) string {
+	for _, attribute := range iconAttributes {
+		if name := strings.TrimSpace(attributes[attribute]); name != "" {
+			return "[" + capitalize(name) + "]"
+		}
+	}
+	return ""
+}
+
+func capitalize(name string) string {
+	first, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(first)) + name[size:]
+}
+
+// formatTime displays a time written in ISO 8601 format in a local time
+// zone. Text that isn't a time is returned as it is.
+func formatTime(text string) string {
+	parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+	if err != nil {
+		return text
+	}
+	return parsed.In(timeLocation).Format(timeLayout)
+}
+
+// parseTime handles <time> element, whose contents are a time.
+func (c htmlConverter) parseTime(datetime string, selfClosing bool) {
+	text := ""
+	if !selfClosing {
+		text = c.parseCell()
+	}
+	if datetime == "" {
+		datetime = formattingRegexp.ReplaceAllString(text, "")
+	}
+	if formatted := formatTime(datetime); formatted != datetime {
+		text = formatted
+	}
+	if !c.isHidden() {
+		c.WriteString(text)
+	}
+}
+
+// parseUsername handles <username> element, which displays a name in
+// bold, with a color based on a name, like in Showdown client. The name
+// can be specified in name attribute, in case it's different from
+// displayed text.
+func (c htmlConverter) parseUsername(name string, selfClosing bool) {
+	text := ""
+	if !selfClosing {
+		text = c.parseCell()
+	}
+	if c.isHidden() {
+		return
+	}
+	if text == "" {
+		text = name
+	}
+	if strings.TrimSpace(name) == "" {
+		name = formattingRegexp.ReplaceAllString(text, "")
+	}
+	user := c
+	user.formatting |= bold
+	changed := user.formatting ^ c.formatting
+	c.WriteString(changed.codes())
+	if c.options.Colors == NoColors {
+		c.WriteString(text)
+		c.WriteString(changed.codes())
+		return
+	}
+	user.foreground = fromImageColor(showdown.NameColor(name))
+	c.WriteString(colorCode(user.foreground, user.background, c.options.Colors == HexColors))
+	c.WriteString(text)
+	c.WriteString(changed.codes())
+	c.restoreColors()
+}
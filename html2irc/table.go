@@ -0,0 +1,208 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package html2irc
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Tables wider than this are displayed without aligning columns, as
+// they wouldn't fit on most screens anyway.
+const maxAlignedTableWidth = 80
+
+const cellSeparator = " | "
+
+var spacesRegexp = regexp.MustCompile(`\s+`)
+
+type tableCell struct {
+	text  string
+	span  int
+	width int
+}
+
+type tableRow struct {
+	cells  []tableCell
+	header bool
+}
+
+// parseTable parses a table, assuming that <table> start tag was
+// already read.
+//
+// The contents of cells are rendered into a single line each. Columns
+// are then aligned with spaces for clients that use monospace fonts,
+// unless the table is too wide, in which case cells are just separated
+// with a vertical bar.
+func (c htmlConverter) parseTable() {
+	var rows []tableRow
+	var current *tableRow
+	finishRow := func() {
+		if current != nil && len(current.cells) != 0 {
+			rows = append(rows, *current)
+		}
+		current = nil
+	}
+
+	for {
+		tt := c.Tokenizer.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.EndTagToken {
+			continue
+		}
+		rawName, hasAttrs := c.TagName()
+		name := string(rawName)
+		if tt == html.EndTagToken {
+			if name == "table" {
+				break
+			} else if name == "tr" {
+				finishRow()
+			}
+			continue
+		}
+
+		switch name {
+		case "tr":
+			finishRow()
+		case "caption":
+			c.printNewline()
+			c.WriteString(c.parseCell())
+		case "td", "th":
+			span := 1
+			for hasAttrs {
+				var key, value []byte
+				key, value, hasAttrs = c.TagAttr()
+				if string(key) == "colspan" {
+					if parsed, err := strconv.Atoi(string(value)); err == nil && parsed > 1 {
+						span = parsed
+					}
+				}
+			}
+			text := c.parseCell()
+			if name == "th" && text != "" {
+				text = bold.codes() + text + bold.codes()
+			}
+			if current == nil {
+				current = &tableRow{header: true}
+			}
+			current.header = current.header && name == "th"
+			current.cells = append(current.cells, tableCell{text, span, displayWidth(text)})
+		}
+	}
+	finishRow()
+
+	c.writeTable(rows)
+}
+
+// parseCell renders contents of an element into a single line of text.
+func (c htmlConverter) parseCell() string {
+	cell := c
+	cell.Buffer = new(bytes.Buffer)
+	cell.preventNewLines = new(bool)
+	cell.inline = true
+	// The formatting of a table is restored on every line, so cells
+	// shouldn't include it.
+	cell.formatting = 0
+	cell.foreground = color{}
+	cell.background = color{}
+	cell.parseToken()
+	text := strings.TrimSpace(spacesRegexp.ReplaceAllString(cell.String(), " "))
+	if isEmpty(text) {
+		return ""
+	}
+	if strings.ContainsAny(text, "\x03\x04\x0F") {
+		// Colors could be reset within a cell
+		text += resetCharacter + c.formattingState()
+	}
+	return text
+}
+
+func (c htmlConverter) writeTable(rows []tableRow) {
+	var widths []int
+	// Cells spanning multiple columns are considered after other cells,
+	// so that the width can be shared between columns.
+	for _, spanning := range []bool{false, true} {
+		for _, row := range rows {
+			column := 0
+			for _, cell := range row.cells {
+				for len(widths) < column+cell.span {
+					widths = append(widths, 0)
+				}
+				if !spanning && cell.span == 1 && cell.width > widths[column] {
+					widths[column] = cell.width
+				}
+				if spanning && cell.span > 1 {
+					last := column + cell.span - 1
+					if available := spanWidth(widths[column : last+1]); cell.width > available {
+						widths[last] += cell.width - available
+					}
+				}
+				column += cell.span
+			}
+		}
+	}
+
+	aligned := spanWidth(widths) <= maxAlignedTableWidth
+	for _, row := range rows {
+		c.printNewline()
+		column := 0
+		for i, cell := range row.cells {
+			if i != 0 {
+				c.WriteString(cellSeparator)
+			}
+			c.WriteString(cell.text)
+			if aligned {
+				width := spanWidth(widths[column : column+cell.span])
+				c.WriteString(strings.Repeat(" ", width-cell.width))
+			}
+			column += cell.span
+		}
+		if aligned && row.header {
+			c.printNewline()
+			separators := make([]string, len(widths))
+			for i, width := range widths {
+				separators[i] = strings.Repeat("-", width)
+			}
+			c.WriteString(strings.Join(separators, "-+-"))
+		}
+	}
+	c.printNewline()
+}
+
+// spanWidth computes width of columns when joined with separators.
+func spanWidth(widths []int) int {
+	if len(widths) == 0 {
+		return 0
+	}
+	total := len(cellSeparator) * (len(widths) - 1)
+	for _, width := range widths {
+		total += width
+	}
+	return total
+}
+
+// displayWidth computes the number of characters in text, excluding
+// formatting codes.
+func displayWidth(text string) int {
+	return utf8.RuneCountInString(formattingRegexp.ReplaceAllString(text, ""))
+}
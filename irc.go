@@ -25,25 +25,101 @@ import (
 	"log"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/xfix/showdown2irc/html2irc"
 	"github.com/xfix/showdown2irc/irc"
 	"github.com/xfix/showdown2irc/showdown"
 )
 
 const serverName = "showdown"
 
+// lineLength is advertised as LINELEN, a limit of lines sent by clients
+// in bytes. It allows MaxMessageLength bytes of text in PRIVMSG to the
+// shortest target. UTF-8 never uses fewer bytes than UTF-16 code units
+// counted by Showdown, so such text fits in one chat message, unless
+// formatting codes get converted to longer markup, in which case the
+// proxy still splits it.
+const lineLength = len("PRIVMSG x :\r\n") + showdown.MaxMessageLength
+
+// Whether names mentioned in notices generated by the proxy should be
+// colored like in Showdown client.
+var colorNicks = false
+
 var tokenRegexp = regexp.MustCompile(`:[^\r\n]*|[^\s:]+`)
 
 type connection struct {
-	tcp          io.WriteCloser
-	nickname     string
-	showdown     *showdown.BotConnection
-	loginData    showdown.LoginData
-	nickObtained bool
-	userObtained bool
-	closing      bool
+	// Accessed atomically, so it's the first field to ensure 64-bit
+	// alignment on 32-bit platforms.
+	lastMessageID uint64
+
+	tcp            io.WriteCloser
+	nickname       string
+	showdown       *showdown.BotConnection
+	loginData      showdown.LoginData
+	nickObtained   bool
+	userObtained   bool
+	capNegotiating bool
+	registered     bool
+	closing        bool
+	capabilities   map[string]bool
+
+	// The last plain text message or popup received from Showdown, if
+	// it was the last thing that Showdown sent. This is used to find
+	// out why an user was removed from a room.
+	lastNotice string
+
+	// IRC commands sent to a room that weren't yet confirmed by
+	// Showdown, indexed by room key, or globalPendingKey for global
+	// commands. Showdown doesn't say which command caused an error,
+	// but it processes commands in order, so those can be used to
+	// figure out which command failed.
+	pendingCommands map[string][]pendingCommand
+	pendingSequence uint64
+	pendingMutex    sync.Mutex
+
+	// Messages created by uhtml command, indexed by room key and
+	// uhtml name.
+	uhtml map[string]map[string]uhtmlMessage
+
+	// IDs of recent chat messages, indexed by room key and author,
+	// used when moderators hide messages.
+	chatMessages map[string]map[showdown.UserID][][]string
+
+	messageIDPrefix string
+
+	// The last battle that requested a decision, used by battle
+	// commands when a room isn't given.
+	lastBattle showdown.RoomID
+	// Guards lastBattle and lastTournament, which are read by IRC
+	// commands
+	lastRoomMutex sync.Mutex
+	// Challenges and battles that were already seen
+	knownChallenges map[showdown.UserID]string
+	knownGames      map[showdown.RoomID]string
+
+	// A team being pasted to TeamBuilder pseudo-user, if any
+	teamImport *teamImport
+
+	// Battle views by room key, and the view of other battles. Those
+	// are changed by IRC commands while battles are shown, so they are
+	// guarded by a mutex.
+	battleViews       map[string]battleView
+	defaultBattleView battleView
+	battleViewMutex   sync.Mutex
+	// Turns which weren't yet summarized in compact view, by room key
+	turnSummaries map[string]*turnSummary
+
+	// The last room with a tournament, used by tournament commands when
+	// a room isn't given
+	lastTournament showdown.RoomID
+	// Notices about the next tournament opponent, by room key, so that
+	// they are only shown when they change
+	tournamentNotices map[string]string
 }
 
 func (c *connection) parseIRCLine(tokens []string) {
@@ -54,14 +130,66 @@ func (c *connection) parseIRCLine(tokens []string) {
 		room := c.showdown.Room(showdown.RoomID(tokens[1][1:]))
 		room.SendCommand(commandName, strings.Join(tokens[2:], ""))
 	} else {
-		c.showdown.SendGlobalCommand(commandName, strings.Join(tokens[1:], " "))
+		c.sendGlobalCommand(commandName, strings.Join(tokens[1:], " "))
 	}
 }
 
 func (c *connection) send(parts ...string) {
-	result := toIRC(parts)
-	log.Print(result)
-	c.tcp.Write([]byte(result))
+	for _, line := range splitMessage(parts) {
+		result := toIRC(line)
+		log.Print(result)
+		c.tcp.Write([]byte(result))
+	}
+}
+
+// sendWithID sends a message with new message IDs, returning them. More
+// than one ID is returned when the message had to be split.
+//
+// The ID is only visible to clients that negotiated message-tags
+// capability, but it's generated anyway, in case the client enables
+// the capability later.
+func (c *connection) sendWithID(parts ...string) []string {
+	var ids []string
+	for _, line := range splitMessage(parts) {
+		id := c.messageIDPrefix + strconv.FormatUint(atomic.AddUint64(&c.lastMessageID, 1), 36)
+		result := toIRC(line)
+		if c.hasCapability("message-tags") {
+			result = "@msgid=" + id + " " + result
+		}
+		log.Print(result)
+		c.tcp.Write([]byte(result))
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+const (
+	actionStart = "\x01ACTION "
+	actionEnd   = "\x01"
+)
+
+// splitMessage splits PRIVMSG and NOTICE messages whose text wouldn't
+// fit in a single IRC line. Other messages are returned as they are.
+func splitMessage(parts []string) [][]string {
+	if len(parts) != 4 || parts[1] != "PRIVMSG" && parts[1] != "NOTICE" {
+		return [][]string{parts}
+	}
+	text := parts[3]
+	limit := irc.MaxTextLength(parts[0], parts[1], parts[2])
+	isAction := strings.HasPrefix(text, actionStart) && strings.HasSuffix(text, actionEnd) && len(text) >= len(actionStart)+len(actionEnd)
+	if isAction {
+		// Every line needs to be an action on its own
+		text = text[len(actionStart) : len(text)-len(actionEnd)]
+		limit -= len(actionStart) + len(actionEnd)
+	}
+	var messages [][]string
+	for _, line := range irc.SplitText(text, limit) {
+		if isAction {
+			line = actionStart + line + actionEnd
+		}
+		messages = append(messages, []string{parts[0], parts[1], parts[2], line})
+	}
+	return messages
 }
 
 func (c *connection) sendGlobal(parts ...string) {
@@ -78,18 +206,192 @@ func (c *connection) sendNumeric(numeric irc.Numeric, parts ...interface{}) {
 	c.tcp.Write([]byte(result))
 }
 
+// sendNumericReason sends a numeric with a custom human readable
+// message, used when Showdown provides a better explanation than the
+// generic message from RFC 1459.
+func (c *connection) sendNumericReason(numeric irc.Numeric, target, reason string) {
+	c.send(serverName, fmt.Sprintf("%03d", numeric), c.nickname, target, reason)
+}
+
 func (c *connection) needMoreParams(command string) {
 	c.sendNumeric(irc.ErrNeedMoreParams, command)
 }
 
+// isSelf checks whether a Showdown user name refers to the user
+// connected to the proxy.
+func (c *connection) isSelf(name string) bool {
+	return showdown.ToID(name) == showdown.ToID(c.loginData.Nickname)
+}
+
+// roomKey normalizes a room ID, so it can be used as a map key.
+func roomKey(room showdown.RoomID) string {
+	id := showdown.ToRoomID(string(room))
+	if id == "" {
+		return "lobby"
+	}
+	return string(id)
+}
+
+// globalPendingKey is the key of pending global commands. Room keys
+// are never empty, so it cannot conflict with any room.
+const globalPendingKey = ""
+
+// pendingTimeout is how long a pending command waits for a response.
+// Showdown doesn't respond to most commands which succeed, so older
+// commands are assumed to have succeeded.
+const pendingTimeout = 10 * time.Second
+
+// pendingCommand is a command sent to Showdown without a response yet.
+type pendingCommand struct {
+	command  string
+	sequence uint64
+	sent     time.Time
+}
+
+// addPendingCommand marks a command as sent to a room, but not yet
+// confirmed by Showdown.
+func (c *connection) addPendingCommand(room showdown.RoomID, command string) {
+	c.addPending(roomKey(room), command)
+}
+
+// sendGlobalCommand sends a global command, remembering it so that an
+// error caused by it can be told apart from errors in the lobby.
+func (c *connection) sendGlobalCommand(command, value string) {
+	c.addPending(globalPendingKey, strings.ToUpper(command))
+	c.showdown.SendGlobalCommand(command, value)
+}
+
+func (c *connection) addPending(key, command string) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	if c.pendingCommands == nil {
+		c.pendingCommands = map[string][]pendingCommand{}
+	}
+	c.pendingSequence++
+	c.pendingCommands[key] = append(c.pendingCommands[key], pendingCommand{command, c.pendingSequence, time.Now()})
+}
+
+// pendingKeys returns keys of commands to which Showdown may respond in
+// a room. Responses to global commands aren't sent to any room, just
+// like messages in the lobby.
+func pendingKeys(room showdown.RoomID) []string {
+	if room == "" {
+		return []string{roomKey(room), globalPendingKey}
+	}
+	return []string{roomKey(room)}
+}
+
+// takePendingCommand removes the oldest pending command with a given
+// name from a room, returning whether there was one. As Showdown
+// responds to commands in order, commands sent before it are dropped,
+// as they won't get a response anymore.
+func (c *connection) takePendingCommand(room showdown.RoomID, command string) bool {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	c.expirePendingCommands()
+	for _, key := range pendingKeys(room) {
+		for _, pending := range c.pendingCommands[key] {
+			if pending.command == command {
+				c.dropPendingCommands(room, pending.sequence)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// popPendingCommand removes the oldest pending command from a room,
+// returning an empty string when there are no pending commands, and
+// whether the command was global.
+func (c *connection) popPendingCommand(room showdown.RoomID) (command string, global bool) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	c.expirePendingCommands()
+	var oldest *pendingCommand
+	for _, key := range pendingKeys(room) {
+		if commands := c.pendingCommands[key]; len(commands) != 0 {
+			if oldest == nil || commands[0].sequence < oldest.sequence {
+				oldest = &commands[0]
+				global = key == globalPendingKey
+			}
+		}
+	}
+	if oldest == nil {
+		return "", false
+	}
+	command = oldest.command
+	c.dropPendingCommands(room, oldest.sequence)
+	return command, global
+}
+
+// dropPendingCommands removes commands sent to a room up to a given
+// sequence number, inclusive.
+func (c *connection) dropPendingCommands(room showdown.RoomID, sequence uint64) {
+	for _, key := range pendingKeys(room) {
+		commands := c.pendingCommands[key]
+		i := 0
+		for i < len(commands) && commands[i].sequence <= sequence {
+			i++
+		}
+		c.setPendingCommands(key, commands[i:])
+	}
+}
+
+// expirePendingCommands removes commands which didn't get a response
+// in pendingTimeout.
+func (c *connection) expirePendingCommands() {
+	for key, commands := range c.pendingCommands {
+		i := 0
+		for i < len(commands) && time.Since(commands[i].sent) > pendingTimeout {
+			i++
+		}
+		c.setPendingCommands(key, commands[i:])
+	}
+}
+
+// clearPendingCommands forgets commands sent to a room, used when the
+// proxy leaves it.
+func (c *connection) clearPendingCommands(room showdown.RoomID) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	delete(c.pendingCommands, roomKey(room))
+}
+
+func (c *connection) setPendingCommands(key string, commands []pendingCommand) {
+	if len(commands) == 0 {
+		delete(c.pendingCommands, key)
+	} else {
+		c.pendingCommands[key] = commands
+	}
+}
+
+// tryRegistration finishes connecting once both NICK and USER were
+// provided, and capability negotiation (if any) has ended.
+func (c *connection) tryRegistration() {
+	if c.registered || c.capNegotiating || !c.nickObtained || !c.userObtained {
+		return
+	}
+	c.registered = true
+	c.continueConnection()
+}
+
 func (c *connection) runShowdownCommand(command, argument string, room *showdown.Room) {
 	if callback, ok := showdownCommands[command]; ok {
 		callback(c, argument, room)
 	}
+	c.sendBattleLog(room)
+	switch command {
+	case "":
+		c.lastNotice = argument
+	case "popup":
+		c.lastNotice = popupText(argument)
+	default:
+		c.lastNotice = ""
+	}
 }
 
 func (c *connection) continueConnection() {
-	showdownConnection, connectionSuccess, err := showdown.ConnectToServer(c.loginData, serverName, c.runShowdownCommand)
+	showdownConnection, connectionSuccess, err := showdown.ConnectToServer(c.loginData, serverName, c.runShowdownCommand, c.eventHandlers())
 	if err != nil {
 		c.sendGlobal("NOTICE", "#", err.Error())
 		c.close()
@@ -100,7 +402,7 @@ func (c *connection) continueConnection() {
 	case <-connectionSuccess:
 		c.sendGlobal("NICK", c.nickname)
 		c.sendNumeric(irc.RplWelcome, "Welcome to Showdown proxy!")
-		c.sendNumeric(irc.RplBounce, "PREFIX=(qraohBv)~#&@%*+")
+		c.sendNumeric(irc.RplBounce, fmt.Sprintf("PREFIX=(qraohBv)~#&@%%*+ LINELEN=%d", lineLength))
 		c.sendNumeric(irc.RplMOTDStart, serverName)
 		c.sendNumeric(irc.RplMOTD, "This server is a proxy server for Pokémon Showdown.")
 		c.sendNumeric(irc.RplMOTD, "For source code, see https://github.com/xfix/showdown2irc")
@@ -133,6 +435,14 @@ func escapeUserWithHost(name string) string {
 	return fmt.Sprintf("%s!%s@%s", escapeUser(name), showdown.ToID(name), serverName)
 }
 
+// coloredNick colors a name, if enabled, for use in notices.
+func coloredNick(name string) string {
+	if !colorNicks {
+		return name
+	}
+	return fmt.Sprintf("\x03%02d%s\x03", html2irc.NearestIRCColor(showdown.NameColor(name)), name)
+}
+
 func escapeRoom(room showdown.RoomID) string {
 	if room == "" {
 		return "#lobby"
@@ -141,6 +451,10 @@ func escapeRoom(room showdown.RoomID) string {
 }
 
 func fromIRC(line string) []string {
+	// Message tags sent by clients aren't used by the proxy
+	if strings.HasPrefix(line, "@") {
+		line = line[strings.IndexAny(line, " \r\n")+1:]
+	}
 	lines := tokenRegexp.FindAllString(line, -1)
 	for i, line := range lines {
 		if line[0] == ':' {
@@ -161,7 +475,7 @@ func toIRC(tokens []string) string {
 			result.WriteByte(':')
 		} else {
 			result.WriteByte(' ')
-			if token == "" || strings.Contains(token, " ") {
+			if token == "" || token[0] == ':' || strings.Contains(token, " ") {
 				result.WriteByte(':')
 				spaceTokenFound = true
 			}
@@ -177,7 +491,17 @@ func connectionListen(rawConnection io.ReadWriteCloser) {
 	lines := bufio.NewReader(rawConnection)
 	var c connection
 
-	c = connection{tcp: rawConnection, nickname: "*"}
+	c = connection{
+		tcp:             rawConnection,
+		nickname:        "*",
+		capabilities:    map[string]bool{},
+		pendingCommands: map[string][]pendingCommand{},
+		uhtml:           map[string]map[string]uhtmlMessage{},
+		chatMessages:    map[string]map[showdown.UserID][][]string{},
+		messageIDPrefix: strconv.FormatInt(time.Now().UnixNano(), 36) + "-",
+
+		defaultBattleView: defaultBattleView,
+	}
 	for !c.closing {
 		line, err := lines.ReadString('\n')
 		if err != nil {
@@ -186,6 +510,9 @@ func connectionListen(rawConnection io.ReadWriteCloser) {
 		}
 		tokens := fromIRC(line)
 		log.Print(tokens)
+		if len(tokens) == 0 {
+			continue
+		}
 		c.parseIRCLine(tokens)
 	}
 }
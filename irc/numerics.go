@@ -77,6 +77,12 @@ const (
 	// parameter.
 	ErrNoOrigin Numeric = 409
 
+	// ErrInvalidCapCmd says that CAP subcommand is not recognized.
+	//
+	// This numeric comes from IRCv3 capability negotiation
+	// specification rather than RFC 1459.
+	ErrInvalidCapCmd Numeric = 410
+
 	// ErrNoRecipient says that recipient parameter was omitted in a
 	// private message.
 	ErrNoRecipient Numeric = 411
@@ -194,9 +200,10 @@ const (
 
 	// ErrInviteOnlyChan says the joined channel is invite only.
 	//
-	// This isn't used on Showdown, as failure to join a channel
-	// because it's invite only looks exactly like failure to enter
-	// a channel because it doesn't exist.
+	// On Showdown, this is used for rooms with modjoin enabled, as
+	// well as for private rooms that say why an user cannot join.
+	// Private rooms that pretend to not exist cause ErrNoSuchChannel
+	// instead.
 	ErrInviteOnlyChan Numeric = 473
 
 	// ErrBannedFromChan is caused by trying to join a channel from
@@ -208,6 +215,13 @@ const (
 	// There are no channel keys on Showdown.
 	ErrBadChannelKey Numeric = 475
 
+	// ErrNeedReggedNick is caused by trying to join a channel that
+	// requires a registered nickname.
+	//
+	// This numeric isn't part of RFC 1459, but is commonly used by IRC
+	// servers. Showdown uses it for rooms that don't allow guests.
+	ErrNeedReggedNick Numeric = 477
+
 	// ErrNoPrivileges is shown when command requires to be an IRC
 	// operator.
 	//
@@ -324,6 +338,7 @@ var numericMessages = map[Numeric]string{
 	ErrWasNoSuchNick:      "%s :There was no such nickname",
 	ErrTooManyTargets:     "%s :Duplicate recipients. No message delivered",
 	ErrNoOrigin:           ":No origin specified",
+	ErrInvalidCapCmd:      "%s :Invalid CAP command",
 	ErrNoRecipient:        ":No recipient given (%s)",
 	ErrNoTextToSend:       ":No text to send",
 	ErrNoTopLevel:         "%s :No toplevel domain specified",
@@ -354,6 +369,7 @@ var numericMessages = map[Numeric]string{
 	ErrInviteOnlyChan:     "%s :Cannot join channel (+i)",
 	ErrBannedFromChan:     "%s :Cannot join channel (+b)",
 	ErrBadChannelKey:      "%s :Cannot join channel (+k)",
+	ErrNeedReggedNick:     "%s :Cannot join channel (+r)",
 	ErrNoPrivileges:       ":Permission Denied- You're not an IRC operator",
 	ErrChanOpPrivIsNeeded: "%s :You're not channel operator",
 	ErrCannotKillServer:   ":You cant kill a server!",
@@ -373,7 +389,7 @@ var numericMessages = map[Numeric]string{
 	RplWhoisOperator: "%s :is an IRC operator",
 	RplWhoisIdle:     "%s %d :seconds idle",
 	RplEndOfWhois:    "%s :End of /WHOIS list",
-	RplWhoisChannels: "%s: %s",
+	RplWhoisChannels: "%s :%s",
 	RplWhowasUser:    "%s %s %s * :%s",
 	RplEndOfWhowas:   "%s :End of WHOWAS",
 	RplListStart:     "Channel :Users  Name",
@@ -0,0 +1,197 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package irc
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLineLength is the maximum length of IRC line in bytes, including
+// CR LF at the end, but excluding message tags.
+const MaxLineLength = 512
+
+// MaxTextLength computes how many bytes can be used for the text of a
+// message (the last parameter) sent by a server, given its prefix,
+// command and target.
+func MaxTextLength(prefix, command, target string) int {
+	return MaxLineLength - len(fmt.Sprintf(":%s %s %s :\r\n", prefix, command, target))
+}
+
+var colorRegexp = regexp.MustCompile(`^(?:\x03(?:(\d{1,2})(?:,(\d{1,2}))?)?|\x04(?:[0-9A-Fa-f]{6}(?:,[0-9A-Fa-f]{6})?)?)`)
+
+// Formatting characters that toggle a style
+const toggleCharacters = "\x02\x1D\x1F\x1E\x11\x16"
+
+const resetCharacter = '\x0F'
+
+// formattingState tracks IRC formatting, so it can be restored on
+// another line.
+type formattingState struct {
+	toggles [len(toggleCharacters)]bool
+	color   string
+}
+
+func (s *formattingState) apply(code string) {
+	if code[0] == resetCharacter {
+		*s = formattingState{}
+		return
+	}
+	for i := range toggleCharacters {
+		if code[0] == toggleCharacters[i] {
+			s.toggles[i] = !s.toggles[i]
+			return
+		}
+	}
+	s.color = normalizeColor(code)
+}
+
+// normalizeColor writes a color code with two digits, so that the text
+// following it couldn't be mistaken as a part of it. Color codes without
+// a color reset colors, so they are normalized to an empty string.
+func normalizeColor(code string) string {
+	if code == "\x03" || code == "\x04" {
+		return ""
+	}
+	if code[0] == '\x04' {
+		return code
+	}
+	match := colorRegexp.FindStringSubmatch(code)
+	foreground, _ := strconv.Atoi(match[1])
+	normalized := fmt.Sprintf("\x03%02d", foreground)
+	if match[2] != "" {
+		background, _ := strconv.Atoi(match[2])
+		normalized += fmt.Sprintf(",%02d", background)
+	}
+	return normalized
+}
+
+func (s formattingState) codes() string {
+	var buffer bytes.Buffer
+	for i, enabled := range s.toggles {
+		if enabled {
+			buffer.WriteByte(toggleCharacters[i])
+		}
+	}
+	buffer.WriteString(s.color)
+	return buffer.String()
+}
+
+// unitLength returns the length of a part of text that cannot be split,
+// that is either a formatting code or a single character, and whether
+// it's a formatting code.
+func unitLength(text string) (int, bool) {
+	if text[0] == resetCharacter || strings.IndexByte(toggleCharacters, text[0]) >= 0 {
+		return 1, true
+	}
+	if text[0] == '\x03' || text[0] == '\x04' {
+		return len(colorRegexp.FindString(text)), true
+	}
+	_, size := utf8.DecodeRuneInString(text)
+	return size, false
+}
+
+// SplitText splits text into lines that don't exceed limit bytes each.
+//
+// Lines are split on spaces when possible, and otherwise on character
+// boundaries, never within a formatting code. As IRC clients reset
+// formatting on every line, formatting that was active at the end of
+// a line is restored at start of the next one.
+func SplitText(text string, limit int) []string {
+	return splitText(text, limit, func(unit string, formatting bool) int {
+		return len(unit)
+	})
+}
+
+// WrapText splits text into lines that don't exceed width characters
+// each, not counting formatting codes. Lines are split like in SplitText.
+func WrapText(text string, width int) []string {
+	return splitText(text, width, func(unit string, formatting bool) int {
+		if formatting {
+			return 0
+		}
+		return 1
+	})
+}
+
+// textWidth computes width of text using a measure function, which
+// computes width of a single unit, as returned by unitLength.
+func textWidth(text string, measure func(string, bool) int) int {
+	width := 0
+	for text != "" {
+		length, formatting := unitLength(text)
+		width += measure(text[:length], formatting)
+		text = text[length:]
+	}
+	return width
+}
+
+func splitText(text string, limit int, measure func(string, bool) int) []string {
+	if textWidth(text, measure) <= limit {
+		return []string{text}
+	}
+	var lines []string
+	var state formattingState
+	for text != "" {
+		prefix := state.codes()
+		budget := limit - textWidth(prefix, measure)
+		if textWidth(text, measure) <= budget {
+			lines = append(lines, prefix+text)
+			break
+		}
+
+		end := 0
+		width := 0
+		lineState := state
+		spaceEnd := -1
+		var spaceState formattingState
+		for end < len(text) {
+			length, formatting := unitLength(text[end:])
+			unitWidth := measure(text[end:end+length], formatting)
+			if width+unitWidth > budget && end != 0 {
+				break
+			}
+			if text[end] == ' ' {
+				spaceEnd = end
+				spaceState = lineState
+			}
+			if formatting {
+				lineState.apply(text[end : end+length])
+			}
+			end += length
+			width += unitWidth
+		}
+
+		next := end
+		if spaceEnd > 0 && end < len(text) && text[end] != ' ' {
+			// Break the line on the last space, removing it
+			end = spaceEnd
+			next = spaceEnd + 1
+			lineState = spaceState
+		} else if end < len(text) && text[end] == ' ' {
+			next = end + 1
+		}
+		lines = append(lines, prefix+text[:end])
+		text = text[next:]
+		state = lineState
+	}
+	return lines
+}
@@ -0,0 +1,49 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var splitTests = []struct {
+	in    string
+	limit int
+	out   []string
+}{
+	{"short", 10, []string{"short"}},
+	{"split on spaces", 10, []string{"split on", "spaces"}},
+	{"exactly ten", 10, []string{"exactly", "ten"}},
+	{"abcdefghijkl", 5, []string{"abcde", "fghij", "kl"}},
+	{"żółć", 5, []string{"żó", "łć"}},
+	{"\x02bold text here", 10, []string{"\x02bold text", "\x02here"}},
+	{"\x0304,12red text", 10, []string{"\x0304,12red", "\x0304,12text"}},
+	{"aaaa\x034bbbb", 7, []string{"aaaa\x034b", "\x0304bbb"}},
+	{"\x1Da\x1D b c d", 5, []string{"\x1Da\x1D b", "c d"}},
+	{"\x02a\x0F bcd efg", 6, []string{"\x02a\x0F", "bcd", "efg"}},
+}
+
+func TestSplitText(t *testing.T) {
+	for _, test := range splitTests {
+		assert.Equal(t, SplitText(test.in, test.limit), test.out, "SplitText(%#q, %d)", test.in, test.limit)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	in := "\x02żółć\x02 \x0304ab cd"
+	out := []string{"\x02żółć\x02", "\x0304ab cd"}
+	assert.Equal(t, WrapText(in, 5), out, "WrapText(%#q, 5)", in)
+}
+
+func TestSplitTextLimit(t *testing.T) {
+	text := strings.Repeat("word ", 1000)
+	limit := MaxTextLength("nick!user@host", "PRIVMSG", "#channel")
+	for _, line := range SplitText(text, limit) {
+		assert.True(t, len(line) <= limit, "line %#q shouldn't exceed %d bytes", line, limit)
+	}
+}
+
+func TestMaxTextLength(t *testing.T) {
+	assert.Equal(t, MaxTextLength("a", "NOTICE", "#b"), 512-len(":a NOTICE #b :\r\n"), "MaxTextLength")
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xfix/showdown2irc/irc"
+	"github.com/xfix/showdown2irc/showdown"
 )
 
 type closeableBuffer struct {
@@ -32,3 +38,359 @@ func TestNeedMoreParams(t *testing.T) {
 	expected := ":showdown 461 * PASS :Not enough parameters\r\n:showdown QUIT *\r\n"
 	assert.Equal(t, out, expected, "PASS with not enough arguments")
 }
+
+func TestCapabilityNegotiation(t *testing.T) {
+	buffer := createBuffer([]string{"CAP LS 302", "CAP REQ :echo-message", "CAP REQ :echo-message unknown", "CAP LIST", "CAP WHAT"})
+	connectionListen(&buffer)
+	out := buffer.String()
+	expected := ":showdown CAP * LS :echo-message message-tags draft/message-redaction\r\n" +
+		":showdown CAP * ACK echo-message\r\n" +
+		":showdown CAP * NAK :echo-message unknown\r\n" +
+		":showdown CAP * LIST echo-message\r\n" +
+		":showdown 410 * WHAT :Invalid CAP command\r\n" +
+		":showdown QUIT *\r\n"
+	assert.Equal(t, out, expected, "CAP negotiation")
+}
+
+func TestJoinFailures(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me", pendingCommands: map[string][]pendingCommand{}}
+	c.addPendingCommand("staff", "JOIN")
+	showdownCommands["noinit"](&c, `joinfailed|You are banned from the room "staff".`, &showdown.Room{ID: "staff"})
+	showdownCommands["noinit"](&c, `nonexistent|The room "nope" does not exist.`, &showdown.Room{ID: "nope"})
+	expected := `:showdown 474 me #staff :You are banned from the room "staff".` + "\r\n" +
+		`:showdown 403 me #nope :The room "nope" does not exist.` + "\r\n"
+	assert.Equal(t, buffer.String(), expected, "noinit responses")
+	assert.Empty(t, c.pendingCommands, "JOIN should not be pending after noinit")
+}
+
+func TestPendingCommands(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me", loginData: showdown.LoginData{Nickname: "me"}}
+	lobby := &showdown.Room{ID: ""}
+	c.addPendingCommand("lobby", "PRIVMSG")
+	c.addPending(globalPendingKey, "CHALLENGE")
+	c.addPendingCommand("lobby", "PRIVMSG")
+	showdownCommands["error"](&c, "Your message was too long.", lobby)
+	showdownCommands["error"](&c, "You are not allowed to challenge.", lobby)
+	showdownCommands["error"](&c, "You are muted.", lobby)
+	expected := ":showdown 404 me #lobby :Your message was too long.\r\n" +
+		":showdown NOTICE me :You are not allowed to challenge.\r\n" +
+		":showdown 404 me #lobby :You are muted.\r\n"
+	assert.Equal(t, buffer.String(), expected, "errors should be attributed in order")
+	assert.Empty(t, c.pendingCommands, "no commands should be pending")
+
+	c.addPending(globalPendingKey, "CHALLENGE")
+	c.addPendingCommand("lobby", "PRIVMSG")
+	c.addPendingCommand("lobby", "PRIVMSG")
+	assert.True(t, c.takePendingCommand("", "PRIVMSG"), "echo should confirm a message")
+	assert.Equal(t, len(c.pendingCommands["lobby"]), 1, "one message should be pending")
+	assert.Empty(t, c.pendingCommands[globalPendingKey], "older global command should be dropped")
+
+	c.pendingCommands["lobby"][0].sent = time.Now().Add(-2 * pendingTimeout)
+	command, _ := c.popPendingCommand("")
+	assert.Equal(t, command, "", "stale command should expire")
+}
+
+func TestDeinit(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me", loginData: showdown.LoginData{Nickname: "me"}}
+	room := &showdown.Room{ID: "lobby"}
+	c.runShowdownCommand("deinit", "", room)
+	c.runShowdownCommand("popup", "|modal|Mod has banned you from the room lobby.", nil)
+	c.runShowdownCommand("deinit", "", room)
+	expected := ":me!me@showdown PART #lobby :\r\n" +
+		":showdown NOTICE me :Mod has banned you from the room lobby.\r\n" +
+		":showdown KICK #lobby me :Mod has banned you from the room lobby.\r\n"
+	assert.Equal(t, buffer.String(), expected, "deinit after leaving and after a ban")
+}
+
+func TestUHTMLChange(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{
+		tcp:             &buffer,
+		nickname:        "me",
+		capabilities:    map[string]bool{"message-tags": true, "draft/message-redaction": true},
+		uhtml:           map[string]map[string]uhtmlMessage{},
+		messageIDPrefix: "id",
+	}
+	room := &showdown.Room{ID: "lobby"}
+	c.runShowdownCommand("uhtmlchange", "poll|<b>unknown</b>", room)
+	c.runShowdownCommand("uhtml", "poll|<div>Poll</div><div>Yes: 0</div>", room)
+	c.runShowdownCommand("uhtmlchange", "poll|<div>Poll</div><div>Yes: 0</div>", room)
+	c.runShowdownCommand("uhtmlchange", "poll|<div>Poll</div><div>Yes: 1</div>", room)
+	c.runShowdownCommand("uhtmlchange", "poll|", room)
+	expected := "@msgid=id1 :showdown NOTICE #lobby Poll\r\n" +
+		"@msgid=id2 :showdown NOTICE #lobby :Yes: 0\r\n" +
+		":showdown REDACT #lobby id1\r\n" +
+		":showdown REDACT #lobby id2\r\n" +
+		"@msgid=id3 :showdown NOTICE #lobby Poll\r\n" +
+		"@msgid=id4 :showdown NOTICE #lobby :Yes: 1\r\n" +
+		":showdown REDACT #lobby id3\r\n" +
+		":showdown REDACT #lobby id4\r\n"
+	assert.Equal(t, buffer.String(), expected, "uhtml updates with message redaction")
+}
+
+func TestHideLines(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{
+		tcp:             &buffer,
+		nickname:        "me",
+		capabilities:    map[string]bool{"message-tags": true, "draft/message-redaction": true},
+		chatMessages:    map[string]map[showdown.UserID][][]string{},
+		messageIDPrefix: "id",
+	}
+	room := &showdown.Room{ID: "lobby", UserList: map[showdown.UserID]showdown.User{"spammer": {Rank: ' ', Name: "Spammer"}}}
+	handlers := c.eventHandlers()
+	handlers.Dispatch(showdown.ParseEvent("c:", "1|+Spammer|a", room))
+	handlers.Dispatch(showdown.ParseEvent("c:", "2|+Spammer|b", room))
+	c.runShowdownCommand("hidelines", "hide|spammer|1", room)
+	c.capabilities = map[string]bool{}
+	c.runShowdownCommand("hidelines", "delete|spammer|5", room)
+	c.runShowdownCommand("hidelines", "hide|spammer|2", room)
+	expected := "@msgid=id1 :Spammer!spammer@showdown PRIVMSG #lobby a\r\n" +
+		"@msgid=id2 :Spammer!spammer@showdown PRIVMSG #lobby b\r\n" +
+		":showdown REDACT #lobby id2 :Message hidden by a moderator\r\n" +
+		":showdown NOTICE #lobby :1 line from Spammer was deleted by a moderator\r\n" +
+		":showdown NOTICE #lobby :Lines from Spammer were hidden by a moderator\r\n"
+	assert.Equal(t, buffer.String(), expected, "hidelines with and without message redaction")
+	assert.Empty(t, c.chatMessages["lobby"], "all messages should be forgotten")
+}
+
+func TestSplitMessage(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me"}
+	prefix := "Someone!someone@showdown"
+	limit := irc.MaxTextLength(prefix, "PRIVMSG", "#lobby")
+	action := "\x01ACTION " + strings.Repeat("a", limit) + "\x01"
+	c.send(prefix, "PRIVMSG", "#lobby", action)
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\r\n"), "\r\n")
+	assert.Len(t, lines, 2, "a long action should be split into two lines")
+	for _, line := range lines {
+		assert.True(t, len(line)+len("\r\n") <= irc.MaxLineLength, "line %#q should fit in IRC line", line)
+		assert.True(t, strings.HasSuffix(line, "\x01"), "line %#q should be an action", line)
+		assert.Contains(t, line, " :\x01ACTION a", "line %#q should be an action", line)
+	}
+}
+
+func TestBattleLog(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me"}
+	room := &showdown.Room{ID: "battle-gen7ou-1", Battle: showdown.NewBattle()}
+	room.Battle.Update("turn", "3")
+	room.Battle.Update("move", "p1a: Pikachu|Thunderbolt|p2a: Gyarados")
+	c.runShowdownCommand("move", "p1a: Pikachu|Thunderbolt|p2a: Gyarados", room)
+	expected := ":showdown NOTICE #battle-gen7ou-1 :\x02Turn 3\r\n" +
+		":showdown NOTICE #battle-gen7ou-1 :Pikachu used Thunderbolt!\r\n"
+	assert.Equal(t, buffer.String(), expected, "battle log should be sent as notices")
+}
+
+func TestBattleRequest(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me"}
+	room := &showdown.Room{ID: "battle-gen7ou-1", Battle: showdown.NewBattle()}
+	request := `{"active":[{"moves":[{"move":"Thunderbolt","pp":15,"maxpp":15},{"move":"Protect","pp":10,"maxpp":10,"disabled":true}]}],` +
+		`"side":{"pokemon":[{"ident":"p1: Sparky","condition":"100/100","active":true},{"ident":"p1: Gyarados","condition":"50/100"}]},"rqid":1}`
+	room.Battle.Update("request", request)
+	c.runShowdownCommand("request", request, room)
+	expected := ":showdown NOTICE #battle-gen7ou-1 :Sparky moves: 1. Thunderbolt (15/15 PP), 2. Protect (disabled)\r\n" +
+		":showdown NOTICE #battle-gen7ou-1 :Switch: 2. Gyarados (50/100)\r\n" +
+		":showdown NOTICE #battle-gen7ou-1 :Choose an action with /move or /switch\r\n"
+	assert.Equal(t, buffer.String(), expected, "battle request should be shown")
+	assert.Equal(t, c.lastBattle, room.ID, "the battle should be remembered for battle commands")
+}
+
+func TestChallenges(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me"}
+	c.showChallenges(showdown.Challenges{From: map[showdown.UserID]string{"alice": "gen7ou"}})
+	c.showChallenges(showdown.Challenges{From: map[showdown.UserID]string{"alice": "gen7ou", "bob": "gen7ubers"}})
+	expected := ":showdown NOTICE me :alice challenges you to a gen7ou battle. Use /accept alice or /reject alice\r\n" +
+		":showdown NOTICE me :bob challenges you to a gen7ubers battle. Use /accept bob or /reject bob\r\n"
+	assert.Equal(t, buffer.String(), expected, "only new challenges should be shown")
+
+	buffer.Reset()
+	for _, command := range []string{"CHALLENGE", "ACCEPT", "REJECT"} {
+		c.parseIRCLine([]string{command})
+	}
+	expected = ":showdown 461 me CHALLENGE :Not enough parameters\r\n" +
+		":showdown 461 me ACCEPT :Not enough parameters\r\n" +
+		":showdown 461 me REJECT :Not enough parameters\r\n"
+	assert.Equal(t, buffer.String(), expected, "missing parameters")
+}
+
+func TestTeamImport(t *testing.T) {
+	directory, err := ioutil.TempDir("", "teams")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+	oldDirectory := teamsDirectory
+	teamsDirectory = directory
+	defer func() { teamsDirectory = oldDirectory }()
+
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me", loginData: showdown.LoginData{Nickname: "Me"}}
+	ircCommands["TEAMS"](&c, []string{"IMPORT", "gen9ou", "Rain Team"})
+	for _, line := range []string{"Pelipper @ Damp Rock", "Ability: Drizzle", "- Hurricane", "Barraskewda", "- Liquidation", "END"} {
+		ircCommands["PRIVMSG"](&c, []string{"TeamBuilder", line})
+	}
+	ircCommands["TEAMS"](&c, []string{"LIST"})
+	expected := ":TeamBuilder!teams@showdown NOTICE me :Paste the team in export format here, then send END\r\n" +
+		":TeamBuilder!teams@showdown NOTICE me :Imported Rain Team with 2 Pokémon for gen9ou\r\n" +
+		":TeamBuilder!teams@showdown NOTICE me :gen9ou: Rain Team\r\n"
+	assert.Equal(t, buffer.String(), expected, "team import")
+	assert.Nil(t, c.teamImport, "import should be finished")
+}
+
+func TestFormats(t *testing.T) {
+	var buffer closeableBuffer
+	formats := showdown.Formats{
+		{ID: "gen9randombattle", Name: "[Gen 9] Random Battle", Section: "S/V Singles", Searchable: true, Challengeable: true},
+		{ID: "gen9ou", Name: "[Gen 9] OU", Section: "S/V Singles", TeamRequired: true, Searchable: true, Challengeable: true},
+		{ID: "gen9vgc2024", Name: "[Gen 9] VGC 2024", Section: "S/V Doubles", TeamRequired: true, Challengeable: true},
+	}
+	c := connection{tcp: &buffer, nickname: "me"}
+	c.sendFormats(formats, nil)
+	c.sendFormats(formats, []string{"gen9"})
+	assert.False(t, c.checkFormat(formats, "gen1ou", isChallengeable, "challenges"), "unknown format")
+	assert.False(t, c.checkFormat(formats, "gen9vgc2024", isSearchable, "ladder searches"), "VGC isn't searchable")
+	assert.True(t, c.checkFormat(formats, "[Gen 9] OU", isSearchable, "ladder searches"), "OU is searchable")
+	assert.True(t, c.checkFormat(nil, "gen1ou", isSearchable, "ladder searches"), "formats can't be checked before the server sends them")
+	expected := ":showdown NOTICE me :Sections: S/V Singles, S/V Doubles. Use /formats query to list formats\r\n" +
+		":showdown NOTICE me :S/V Singles: [Gen 9] Random Battle, [Gen 9] OU\r\n" +
+		":showdown NOTICE me :S/V Doubles: [Gen 9] VGC 2024\r\n" +
+		":showdown NOTICE me :Unknown format gen1ou, use /formats to find formats\r\n" +
+		":showdown NOTICE me :[Gen 9] VGC 2024 can't be used for ladder searches\r\n"
+	assert.Equal(t, buffer.String(), expected, "formats")
+}
+
+func TestExportBattleLog(t *testing.T) {
+	directory, err := ioutil.TempDir("", "replays")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+	oldDirectory := replaysDirectory
+	replaysDirectory = directory
+	defer func() { replaysDirectory = oldDirectory }()
+
+	room := &showdown.Room{ID: "battle-gen7ou-1", Battle: showdown.NewBattle()}
+	room.Battle.Update("turn", "1")
+	path, err := exportBattleLog(room, "txt")
+	assert.NoError(t, err)
+	contents, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(contents), "Turn 1\n", "text log")
+	_, err = exportBattleLog(room, "pdf")
+	assert.Error(t, err, "unknown formats should be rejected")
+}
+
+func TestCompactBattleView(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me", defaultBattleView: compactView}
+	room := &showdown.Room{ID: "battle-gen7ou-1", Battle: showdown.NewBattle()}
+	protocol := []string{
+		"|player|p1|Alice|1", "|player|p2|Bob|2", "|start",
+		"|switch|p1a: Sparky|Pikachu, M|100/100", "|switch|p2a: Charizard|Charizard|100/100",
+		"|turn|1", "|move|p1a: Sparky|Thunderbolt|p2a: Charizard", "|-supereffective|p2a: Charizard",
+		"|-damage|p2a: Charizard|0 fnt", "|faint|p2a: Charizard", "|win|Alice",
+	}
+	for _, line := range protocol {
+		parts := append(strings.SplitN(line[1:], "|", 2), "")
+		room.Battle.Update(parts[0], parts[1])
+		c.runShowdownCommand(parts[0], parts[1], room)
+	}
+	expected := ":showdown NOTICE #battle-gen7ou-1 :\x02Battle started between Alice and Bob!\r\n" +
+		":showdown NOTICE #battle-gen7ou-1 :Alice sent out Sparky (Pikachu)! Bob sent out Charizard!\r\n" +
+		":showdown NOTICE #battle-gen7ou-1 :\x02Turn 1:\x02 Sparky used Thunderbolt!\r\n" +
+		":showdown NOTICE #battle-gen7ou-1 :Charizard fainted!\r\n" +
+		":showdown NOTICE #battle-gen7ou-1 :\x02Alice won the battle!\r\n"
+	assert.Equal(t, buffer.String(), expected, "compact battle view")
+	assert.Empty(t, c.turnSummaries, "summaries should be sent once a battle ends")
+}
+
+func TestResultsBattleView(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me", defaultBattleView: resultsView}
+	// The room isn't connected, so saving a replay would panic
+	room := &showdown.Room{ID: "battle-gen7ou-1", Battle: showdown.NewBattle()}
+	for _, line := range []string{"|player|p1|Alice|1", "|player|p2|Bob|2", "|start", "|turn|1", "|win|Alice"} {
+		parts := append(strings.SplitN(line[1:], "|", 2), "")
+		room.Battle.Update(parts[0], parts[1])
+		c.runShowdownCommand(parts[0], parts[1], room)
+	}
+	expected := ":showdown NOTICE #battle-gen7ou-1 :\x02Alice won the battle!\r\n"
+	assert.Equal(t, buffer.String(), expected, "only the winner should be shown, without saving a replay")
+}
+
+func TestTournamentNotices(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me"}
+	room := &showdown.Room{ID: "tours", Tournament: &showdown.Tournament{Format: "gen9ou", Generator: "Single Elimination"}}
+	c.runShowdownCommand("tournament", "create|gen9ou|Single Elimination|8", room)
+	room.Tournament.Started = true
+	room.Tournament.Joined = true
+	room.Tournament.Challenges = []showdown.UserID{"bob"}
+	c.runShowdownCommand("tournament", "updateEnd", room)
+	c.runShowdownCommand("tournament", "updateEnd", room)
+	c.runShowdownCommand("tournament", "battleend|Bob|Carol|loss|0,1|success|battle-gen9ou-1", room)
+	expected := ":showdown NOTICE #tours :Signups are open for a gen9ou Single Elimination tournament. Use /tour join #tours to join\r\n" +
+		":showdown NOTICE #tours :Your next opponent: bob. Use /tour challenge #tours\r\n" +
+		":showdown NOTICE #tours :Carol beat Bob (1-0)\r\n"
+	assert.Equal(t, buffer.String(), expected, "tournament notices")
+	assert.Equal(t, c.getLastTournament(), room.ID, "the room should be remembered for tournament commands")
+}
+
+func TestUserDetails(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me"}
+	c.sendUserDetails("Alice", showdown.UserDetails{ID: "alice", Name: "Alice", Group: "+", Status: "busy", Rooms: showdown.UserRooms{"lobby": '@', "techcode": ' '}})
+	c.sendUserDetails("Bob", showdown.UserDetails{ID: "bob", Name: "Bob"})
+	expected := ":showdown 311 me Alice alice showdown * :Global rank: +\r\n" +
+		":showdown 319 me Alice :@#lobby #techcode\r\n" +
+		":showdown 301 me Alice :busy\r\n" +
+		":showdown 318 me Alice :End of /WHOIS list\r\n" +
+		":showdown 401 me Bob :No such nick/channel\r\n" +
+		":showdown 318 me Bob :End of /WHOIS list\r\n"
+	assert.Equal(t, buffer.String(), expected, "WHOIS replies")
+}
+
+func TestRoomList(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{tcp: &buffer, nickname: "me"}
+	c.sendRoomList(showdown.RoomsInfo{
+		Official: []showdown.RoomInfo{{Title: "Lobby", Description: "Chat", UserCount: 100}},
+		Chat:     []showdown.RoomInfo{{Title: "Tech & Code", Description: "Programming", UserCount: 20}},
+	})
+	expected := ":showdown 321 me Channel :Users  Name\r\n" +
+		":showdown 322 me #lobby 100 :Chat\r\n" +
+		":showdown 322 me #techcode 20 :Programming\r\n" +
+		":showdown 323 me :End of /LIST\r\n"
+	assert.Equal(t, buffer.String(), expected, "LIST replies")
+}
+
+func TestEvents(t *testing.T) {
+	var buffer closeableBuffer
+	c := connection{
+		tcp:          &buffer,
+		nickname:     "me",
+		loginData:    showdown.LoginData{Nickname: "Me"},
+		chatMessages: map[string]map[showdown.UserID][][]string{},
+	}
+	room := &showdown.Room{ID: "lobby"}
+	handlers := c.eventHandlers()
+	handlers.Dispatch(showdown.ParseEvent("J", "@Alice", room))
+	handlers.Dispatch(showdown.ParseEvent("j", " Bob", room))
+	handlers.Dispatch(showdown.ParseEvent("J", " Me", room))
+	handlers.Dispatch(showdown.ParseEvent("c:", "1500000000|@Alice|hello", room))
+	handlers.Dispatch(showdown.ParseEvent("c", " Bob|hi", room))
+	handlers.Dispatch(showdown.ParseEvent("pm", "@Alice| Me|hi", room))
+	handlers.Dispatch(showdown.ParseEvent("L", "@Alice", room))
+	handlers.Dispatch(showdown.ParseEvent("l", " Bob", room))
+	expected := ":Alice!alice@showdown JOIN #lobby\r\n" +
+		":showdown MODE #lobby +o Alice\r\n" +
+		":Bob!bob@showdown JOIN #lobby\r\n" +
+		":Alice!alice@showdown PRIVMSG #lobby hello\r\n" +
+		":Bob!bob@showdown PRIVMSG #lobby hi\r\n" +
+		":Alice!alice@showdown PRIVMSG me hi\r\n" +
+		":Alice!alice@showdown PART #lobby :\r\n" +
+		":Bob!bob@showdown PART #lobby :\r\n"
+	assert.Equal(t, buffer.String(), expected, "events")
+}
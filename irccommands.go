@@ -20,14 +20,13 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/xfix/showdown2irc/chatformat"
 	"github.com/xfix/showdown2irc/irc"
 	"github.com/xfix/showdown2irc/showdown"
 )
 
 var ircCommands = map[string]func(*connection, []string){
-	"CAP": func(c *connection, command []string) {
-		// Not implemented, does nothing
-	},
+	"CAP": capCommand,
 	"PASS": func(c *connection, command []string) {
 		if len(command) < 1 {
 			c.needMoreParams("PASS")
@@ -38,21 +37,22 @@ var ircCommands = map[string]func(*connection, []string){
 		}
 	},
 	"NICK": func(c *connection, command []string) {
-		if c.userObtained && !c.nickObtained {
-			c.continueConnection()
-		}
 		c.nickObtained = true
+		c.tryRegistration()
 	},
 	"USER": func(c *connection, command []string) {
 		if len(command) < 4 {
 			c.needMoreParams("USER")
+			return
+		}
+		if c.userObtained {
+			c.sendNumeric(irc.ErrAlreadyRegistered)
+			return
 		}
 		c.loginData.Nickname = command[3]
 		c.nickname = escapeUser(command[3])
-		if !c.userObtained && c.nickObtained {
-			c.continueConnection()
-		}
 		c.userObtained = true
+		c.tryRegistration()
 	},
 	"OPER": func(c *connection, command []string) {
 		// The server doesn't support OPER command, so claim that the current
@@ -77,6 +77,10 @@ var ircCommands = map[string]func(*connection, []string){
 	},
 	"PRIVMSG": func(c *connection, command []string) {
 		message := unescapeUser(command[1])
+		if strings.EqualFold(command[0], teamBuilderNick) {
+			c.addTeamLine(message)
+			return
+		}
 		const actionPrefix = "\x01ACTION "
 		const actionSuffix = "\x01"
 		var roomMethod func(*showdown.Room, string)
@@ -92,11 +96,23 @@ var ircCommands = map[string]func(*connection, []string){
 				room.Reply(message)
 			}
 		}
+		// Messages may need one more character when escaped
+		limit := showdown.MaxMessageLength - len(pmCommand) - 1
+		parts := chatformat.SplitFromIRC(message, limit)
 		if command[0][0] == '#' {
 			room := c.showdown.Room(showdown.RoomID(command[0][1:]))
-			roomMethod(room, message)
+			for _, part := range parts {
+				c.addPendingCommand(room.ID, "PRIVMSG")
+				roomMethod(room, part)
+			}
 		} else if command[1] != "NickServ" {
-			c.showdown.SendGlobalCommand("pm", fmt.Sprintf("%s,%s%s", command[0], pmCommand, message))
+			for i, part := range parts {
+				// Only the first part can be a command
+				if i != 0 && pmCommand == "" {
+					part = showdown.EscapeMessage(part)
+				}
+				c.showdown.SendGlobalCommand("pm", fmt.Sprintf("%s,%s%s", command[0], pmCommand, part))
+			}
 		}
 	},
 	"JOIN": func(c *connection, command []string) {
@@ -107,18 +123,62 @@ var ircCommands = map[string]func(*connection, []string){
 			if room[0] == '#' {
 				room = room[1:]
 			}
+			c.addPendingCommand(showdown.RoomID(room), "JOIN")
 			c.showdown.SendGlobalCommand("join", room)
 		}
 	},
 	"PART": func(c *connection, command []string) {
-		room := c.showdown.Room(showdown.RoomID(command[0][1:]))
-		room.SendCommand("part", "")
+		if len(command) < 1 {
+			c.needMoreParams("PART")
+			return
+		}
+		for _, channel := range strings.Split(command[0], ",") {
+			room, ok := c.showdown.JoinedRoom(channel)
+			if !ok {
+				c.sendNumeric(irc.ErrNotOnChannel, channel)
+				continue
+			}
+			room.SendCommand("part", "")
+		}
 	},
 	"MODE": func(c *connection, command []string) {
 		if len(command) == 1 {
 			c.sendNumeric(irc.RplChannelModeIs, command[0], "+ntc", "")
 		}
 	},
+	"TAGMSG": func(c *connection, command []string) {
+		// Showdown has no concept of typing notifications or other
+		// tag-only messages, so those are ignored.
+	},
+	"REDACT": func(c *connection, command []string) {
+		if len(command) < 2 {
+			c.needMoreParams("REDACT")
+			return
+		}
+		// Showdown doesn't let users delete their own messages, and
+		// moderators remove messages with /hidetext instead.
+		c.sendGlobal("FAIL", "REDACT", "REDACT_FORBIDDEN", command[0], command[1], "You are not authorised to delete this message")
+	},
+	"MOVE":         battleChoice("move"),
+	"SWITCH":       battleChoice("switch"),
+	"TEAM":         battleChoice("team"),
+	"UNDO":         battleCommand("undo"),
+	"FORFEIT":      battleCommand("forfeit"),
+	"TIMER":        battleCommand("timer"),
+	"SAVEREPLAY":   battleCommand("savereplay"),
+	"EXPORTLOG":    exportLogCommand,
+	"BATTLEVIEW":   battleViewCommand,
+	"TOUR":         tournamentCommand,
+	"WHOIS":        whoisCommand,
+	"LIST":         listCommand,
+	"CHALLENGE":    formatCommand("challenge", 1, isChallengeable, "challenges"),
+	"ACCEPT":       showdownCommand("accept", 1),
+	"REJECT":       showdownCommand("reject", 1),
+	"CANCEL":       cancelChallenge,
+	"SEARCH":       formatCommand("search", 0, isSearchable, "ladder searches"),
+	"CANCELSEARCH": showdownCommand("cancelsearch", 0),
+	"TEAMS":        teamsCommand,
+	"FORMATS":      listFormats,
 	"QUIT": func(c *connection, command []string) {
 		c.close()
 	},
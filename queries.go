@@ -0,0 +1,94 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/xfix/showdown2irc/irc"
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// whoisCommand answers WHOIS with details of a Showdown user. Queries
+// wait for the server, so they are run in the background.
+func whoisCommand(c *connection, command []string) {
+	if len(command) < 1 {
+		c.sendNumeric(irc.ErrNoNicknameGiven)
+		return
+	}
+	// The first parameter is a server when two are given
+	nick := command[len(command)-1]
+	go func() {
+		details, err := c.showdown.QueryUserDetails(context.Background(), unescapeUser(nick))
+		if err != nil {
+			c.sendNumericReason(irc.ErrNoSuchNick, nick, err.Error())
+			c.sendNumeric(irc.RplEndOfWhois, nick)
+			return
+		}
+		c.sendUserDetails(nick, details)
+	}()
+}
+
+func (c *connection) sendUserDetails(nick string, details showdown.UserDetails) {
+	if !details.Online() {
+		c.sendNumeric(irc.ErrNoSuchNick, nick)
+		c.sendNumeric(irc.RplEndOfWhois, nick)
+		return
+	}
+	name := escapeUser(details.Name)
+	c.sendNumeric(irc.RplWhoisUser, name, string(details.ID), serverName, "Global rank: "+details.Group)
+
+	var rooms []string
+	for room := range details.Rooms {
+		rooms = append(rooms, string(room))
+	}
+	sort.Strings(rooms)
+	for i, room := range rooms {
+		prefix := ""
+		if rank := details.Rooms[showdown.RoomID(room)]; rank != ' ' {
+			prefix = string(rank)
+		}
+		rooms[i] = prefix + escapeRoom(showdown.RoomID(room))
+	}
+	c.sendNumeric(irc.RplWhoisChannels, name, strings.Join(rooms, " "))
+	if details.Status != "" {
+		c.sendNumeric(irc.RplAway, name, details.Status)
+	}
+	c.sendNumeric(irc.RplEndOfWhois, name)
+}
+
+// listCommand answers LIST with public chat rooms.
+func listCommand(c *connection, command []string) {
+	go func() {
+		rooms, err := c.showdown.QueryRooms(context.Background())
+		if err != nil {
+			c.sendGlobal("NOTICE", c.nickname, "Couldn't list rooms: "+err.Error())
+			return
+		}
+		c.sendRoomList(rooms)
+	}()
+}
+
+func (c *connection) sendRoomList(rooms showdown.RoomsInfo) {
+	c.sendNumeric(irc.RplListStart)
+	for _, room := range rooms.All() {
+		c.sendNumeric(irc.RplList, escapeRoom(showdown.ToRoomID(room.Title)), room.UserCount, room.Description)
+	}
+	c.sendNumeric(irc.RplListEnd)
+}
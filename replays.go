@@ -0,0 +1,97 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// Directory to which battle logs are exported
+var replaysDirectory = filepath.Join(os.Getenv("HOME"), ".showdown2irc", "replays")
+
+// uploadReplay uploads a replay sent in response to /savereplay, and
+// notifies about its address once it's uploaded.
+func (c *connection) uploadReplay(rawMessage string) {
+	var replay showdown.ReplayData
+	if err := json.Unmarshal([]byte(rawMessage), &replay); err != nil || replay.Silent {
+		return
+	}
+	// The link is shown in a battle room, when it's still open
+	target := c.nickname
+	if room := showdown.RoomID("battle-" + replay.ID); c.showdown.InRoom(room) {
+		target = escapeRoom(room)
+	}
+	go func() {
+		address, err := showdown.UploadReplay(replay)
+		if err != nil {
+			c.sendGlobal("NOTICE", target, fmt.Sprintf("Couldn't save the replay of %s: %s", replay.ID, err))
+			return
+		}
+		c.sendGlobal("NOTICE", target, fmt.Sprintf("Replay of %s saved: %s", replay.ID, address))
+	}()
+}
+
+// Battle log formats, by file extension
+var battleLogFormats = map[string]func(battle *showdown.Battle, room showdown.RoomID) string{
+	"html": func(battle *showdown.Battle, room showdown.RoomID) string {
+		return battle.ReplayHTML(room)
+	},
+	"txt": func(battle *showdown.Battle, room showdown.RoomID) string {
+		return battle.Transcript()
+	},
+}
+
+// exportBattleLog saves a battle log in replaysDirectory, returning
+// a path to it.
+func exportBattleLog(room *showdown.Room, extension string) (string, error) {
+	format, ok := battleLogFormats[extension]
+	if !ok {
+		return "", fmt.Errorf("unknown format %s, use html or txt", extension)
+	}
+	if err := os.MkdirAll(replaysDirectory, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(replaysDirectory, string(room.ID)+"."+extension)
+	return path, ioutil.WriteFile(path, []byte(format(room.Battle, room.ID)), 0600)
+}
+
+func exportLogCommand(c *connection, command []string) {
+	room, params := c.battleRoom(command)
+	if room == nil {
+		return
+	}
+	extension := "html"
+	if len(params) > 0 {
+		extension = strings.ToLower(params[0])
+		if extension == "text" {
+			extension = "txt"
+		}
+	}
+	path, err := exportBattleLog(room, extension)
+	if err != nil {
+		c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("Couldn't export the battle log: %s", err))
+		return
+	}
+	c.sendGlobal("NOTICE", c.nickname, "Battle log exported to "+path)
+}
@@ -0,0 +1,443 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Keyword arguments look like [from] item: Life Orb. Format names, like
+// [Gen 7] OU, aren't keyword arguments.
+var kwargRegexp = regexp.MustCompile(`^\[(\w+)\](.*)$`)
+
+// Battle represents a state of a battle, as seen by battle protocol
+// messages.
+type Battle struct {
+	// Player names by side, like p1
+	Players map[string]string
+	// Active Pokémon by position, like p1a
+	Active map[string]*BattlePokemon
+	// Pokémon that were seen in a battle, by side and name, like
+	// "p1: Pikachu"
+	Pokemon map[string]*BattlePokemon
+	// Name of a format, like [Gen 7] OU
+	Format string
+	// Species shown in team preview, by side
+	Preview map[string][]string
+	Turn    int
+	Winner  string
+	Ended   bool
+	// The last request for a decision, nil when not playing
+	request *BattleRequest
+	// Guards the request and logs, which are read by other goroutines
+	// than the one updating a battle
+	mutex sync.Mutex
+
+	log   []BattleLine
+	taken int
+	// Protocol messages, as they would appear in a replay
+	protocol []string
+	// Command being handled, stored in lines of text log
+	command string
+}
+
+// BattlePokemon represents a Pokémon in a battle.
+type BattlePokemon struct {
+	Name    string
+	Species string
+	HP      int
+	MaxHP   int
+	Status  string
+	Fainted bool
+}
+
+// HPPercent returns the health of a Pokémon in percents.
+func (p *BattlePokemon) HPPercent() int {
+	if p.MaxHP == 0 {
+		return 0
+	}
+	return (p.HP*100 + p.MaxHP/2) / p.MaxHP
+}
+
+// BattleLine is a line of text battle log.
+type BattleLine struct {
+	Text string
+	// Headings are lines that start turns or end battles.
+	Heading bool
+	// Protocol command that added the line, like move
+	Command string
+}
+
+// NewBattle creates a state of a battle that didn't start yet.
+func NewBattle() *Battle {
+	return &Battle{
+		Players: map[string]string{},
+		Active:  map[string]*BattlePokemon{},
+		Pokemon: map[string]*BattlePokemon{},
+		Preview: map[string][]string{},
+	}
+}
+
+// Request returns the last request for a decision, nil when not
+// playing.
+func (b *Battle) Request() *BattleRequest {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.request
+}
+
+// Log returns a copy of the whole text log of a battle.
+func (b *Battle) Log() []BattleLine {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]BattleLine(nil), b.log...)
+}
+
+// TakeLines returns lines of text log that were added since the last
+// call.
+func (b *Battle) TakeLines() []BattleLine {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	lines := append([]BattleLine(nil), b.log[b.taken:]...)
+	b.taken = len(b.log)
+	return lines
+}
+
+func (b *Battle) write(format string, arguments ...interface{}) {
+	b.log = append(b.log, BattleLine{Text: fmt.Sprintf(format, arguments...), Command: b.command})
+}
+
+func (b *Battle) writeHeading(format string, arguments ...interface{}) {
+	b.log = append(b.log, BattleLine{Text: fmt.Sprintf(format, arguments...), Heading: true, Command: b.command})
+}
+
+// Update updates a battle state with a protocol message, adding lines
+// to the text log.
+func (b *Battle) Update(command, argument string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !unrecordedCommands[command] {
+		line := "|" + command
+		if argument != "" {
+			line += "|" + argument
+		}
+		b.protocol = append(b.protocol, line)
+	}
+	if command == "request" {
+		// JSON can contain vertical bars, so it cannot be split
+		b.setRequest(argument)
+		return
+	}
+	args := strings.Split(argument, "|")
+	// Keyword arguments, like [from], are at the end
+	kwargs := map[string]string{}
+	for len(args) > 0 {
+		match := kwargRegexp.FindStringSubmatch(args[len(args)-1])
+		if match == nil {
+			break
+		}
+		args = args[:len(args)-1]
+		kwargs[match[1]] = strings.TrimSpace(match[2])
+	}
+	b.command = command
+	if handler, ok := battleHandlers[command]; ok && len(args) >= handler.arguments {
+		handler.handle(b, args, kwargs)
+	}
+}
+
+var battleHandlers = map[string]struct {
+	arguments int
+	handle    func(b *Battle, args []string, kwargs map[string]string)
+}{
+	"player": {2, func(b *Battle, args []string, kwargs map[string]string) {
+		if args[1] != "" {
+			b.Players[args[0]] = args[1]
+		}
+	}},
+	"tier": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		b.Format = args[0]
+	}},
+	"clearpoke": {0, func(b *Battle, args []string, kwargs map[string]string) {
+		b.Preview = map[string][]string{}
+	}},
+	"poke": {2, func(b *Battle, args []string, kwargs map[string]string) {
+		species := strings.SplitN(args[1], ",", 2)[0]
+		b.Preview[args[0]] = append(b.Preview[args[0]], species)
+	}},
+	"teampreview": {0, func(b *Battle, args []string, kwargs map[string]string) {
+		b.writeHeading("Team preview: %s: %s / %s: %s",
+			b.Players["p1"], strings.Join(b.Preview["p1"], ", "),
+			b.Players["p2"], strings.Join(b.Preview["p2"], ", "))
+	}},
+	"start": {0, func(b *Battle, args []string, kwargs map[string]string) {
+		b.writeHeading("Battle started between %s and %s!", b.Players["p1"], b.Players["p2"])
+	}},
+	"turn": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		b.Turn, _ = strconv.Atoi(args[0])
+		b.writeHeading("Turn %d", b.Turn)
+	}},
+	"switch":  {3, switchIn},
+	"drag":    {3, switchIn},
+	"replace": {2, switchIn},
+	"move": {2, func(b *Battle, args []string, kwargs map[string]string) {
+		b.write("%s used %s!", b.pokemon(args[0]).Name, args[1])
+	}},
+	"cant": {2, func(b *Battle, args []string, kwargs map[string]string) {
+		name := b.pokemon(args[0]).Name
+		switch args[1] {
+		case "par":
+			b.write("%s is paralyzed! It can't move!", name)
+		case "slp":
+			b.write("%s is fast asleep.", name)
+		case "frz":
+			b.write("%s is frozen solid!", name)
+		default:
+			b.write("%s can't move!", name)
+		}
+	}},
+	"faint": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		pokemon := b.pokemon(args[0])
+		pokemon.Fainted = true
+		pokemon.HP = 0
+		b.write("%s fainted!", pokemon.Name)
+	}},
+	"-damage": {2, func(b *Battle, args []string, kwargs map[string]string) {
+		pokemon := b.pokemon(args[0])
+		before := pokemon.HPPercent()
+		pokemon.setCondition(args[1])
+		b.write("%s lost %d%% of its health%s!", pokemon.Name, before-pokemon.HPPercent(), fromEffect(kwargs))
+	}},
+	"-heal": {2, func(b *Battle, args []string, kwargs map[string]string) {
+		pokemon := b.pokemon(args[0])
+		before := pokemon.HPPercent()
+		pokemon.setCondition(args[1])
+		b.write("%s restored %d%% of its health%s!", pokemon.Name, pokemon.HPPercent()-before, fromEffect(kwargs))
+	}},
+	"-sethp": {2, func(b *Battle, args []string, kwargs map[string]string) {
+		b.pokemon(args[0]).setCondition(args[1])
+	}},
+	"-status": {2, func(b *Battle, args []string, kwargs map[string]string) {
+		pokemon := b.pokemon(args[0])
+		pokemon.Status = args[1]
+		if description, ok := statusDescriptions[args[1]]; ok {
+			b.write("%s %s!", pokemon.Name, description)
+		}
+	}},
+	"-curestatus": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		pokemon := b.pokemon(args[0])
+		pokemon.Status = ""
+		b.write("%s's status was cured!", pokemon.Name)
+	}},
+	"-boost":   {3, statChange("rose", "rose sharply", "rose drastically")},
+	"-unboost": {3, statChange("fell", "fell harshly", "fell severely")},
+	"-supereffective": {0, func(b *Battle, args []string, kwargs map[string]string) {
+		b.write("It's super effective!")
+	}},
+	"-resisted": {0, func(b *Battle, args []string, kwargs map[string]string) {
+		b.write("It's not very effective...")
+	}},
+	"-immune": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		b.write("It doesn't affect %s...", b.pokemon(args[0]).Name)
+	}},
+	"-crit": {0, func(b *Battle, args []string, kwargs map[string]string) {
+		b.write("A critical hit!")
+	}},
+	"-miss": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		if len(args) > 1 && args[1] != "" {
+			b.write("%s avoided the attack!", b.pokemon(args[1]).Name)
+		} else {
+			b.write("%s's attack missed!", b.pokemon(args[0]).Name)
+		}
+	}},
+	"-fail": {0, func(b *Battle, args []string, kwargs map[string]string) {
+		b.write("But it failed!")
+	}},
+	"-weather": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		if _, upkeep := kwargs["upkeep"]; upkeep {
+			return
+		}
+		if description, ok := weatherDescriptions[args[0]]; ok {
+			b.write("%s", description)
+		}
+	}},
+	"win": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		b.Winner = args[0]
+		b.Ended = true
+		b.writeHeading("%s won the battle!", args[0])
+	}},
+	"tie": {0, func(b *Battle, args []string, kwargs map[string]string) {
+		b.Ended = true
+		b.writeHeading("Tie between %s and %s!", b.Players["p1"], b.Players["p2"])
+	}},
+	"message":  {1, message},
+	"-message": {1, message},
+	"inactive": {1, message},
+	"-hint": {1, func(b *Battle, args []string, kwargs map[string]string) {
+		b.write("(%s)", args[0])
+	}},
+}
+
+var statusDescriptions = map[string]string{
+	"brn": "was burned",
+	"par": "is paralyzed! It may be unable to move",
+	"slp": "fell asleep",
+	"frz": "was frozen solid",
+	"psn": "was poisoned",
+	"tox": "was badly poisoned",
+}
+
+var weatherDescriptions = map[string]string{
+	"none":          "The weather cleared up.",
+	"SunnyDay":      "The sunlight turned harsh!",
+	"RainDance":     "It started to rain!",
+	"Sandstorm":     "A sandstorm kicked up!",
+	"Hail":          "It started to hail!",
+	"Snow":          "It started to snow!",
+	"DesolateLand":  "The sunlight turned extremely harsh!",
+	"PrimordialSea": "A heavy rain began to fall!",
+	"DeltaStream":   "Mysterious strong winds are protecting Flying-type Pokémon!",
+}
+
+var statNames = map[string]string{
+	"atk":      "Attack",
+	"def":      "Defense",
+	"spa":      "Sp. Atk",
+	"spd":      "Sp. Def",
+	"spe":      "Speed",
+	"accuracy": "accuracy",
+	"evasion":  "evasiveness",
+}
+
+func switchIn(b *Battle, args []string, kwargs map[string]string) {
+	position, name := splitPokemonID(args[0])
+	if len(position) < 2 {
+		// Malformed position, which doesn't say whose Pokémon it is
+		return
+	}
+	pokemon := b.pokemon(args[0])
+	pokemon.Species = strings.SplitN(args[1], ",", 2)[0]
+	if len(args) > 2 {
+		pokemon.setCondition(args[2])
+	}
+	b.Active[position] = pokemon
+
+	displayed := name
+	if pokemon.Species != name {
+		displayed = fmt.Sprintf("%s (%s)", name, pokemon.Species)
+	}
+	player := b.Players[position[:2]]
+	if player == "" {
+		player = position[:2]
+	}
+	b.write("%s sent out %s!", player, displayed)
+}
+
+// statChange creates a handler for stat changes, which describes them
+// using given verbs, for changes by 1, 2, and 3 or more stages.
+func statChange(verbs ...string) func(*Battle, []string, map[string]string) {
+	return func(b *Battle, args []string, kwargs map[string]string) {
+		name := b.pokemon(args[0]).Name
+		stat := statNames[args[1]]
+		if stat == "" {
+			stat = args[1]
+		}
+		amount, _ := strconv.Atoi(args[2])
+		if amount < 1 {
+			amount = 1
+		} else if amount > len(verbs) {
+			amount = len(verbs)
+		}
+		b.write("%s's %s %s!", name, stat, verbs[amount-1])
+	}
+}
+
+func message(b *Battle, args []string, kwargs map[string]string) {
+	b.write("%s", strings.Join(args, "|"))
+}
+
+// fromEffect describes a cause of damage or healing, like " from
+// Leftovers".
+func fromEffect(kwargs map[string]string) string {
+	effect := kwargs["from"]
+	if effect == "" {
+		return ""
+	}
+	// Effects can be prefixed with a type, like item: Leftovers
+	if colon := strings.Index(effect, ": "); colon >= 0 {
+		effect = effect[colon+2:]
+	}
+	return " from " + effect
+}
+
+// pokemon returns a Pokémon with a given ID, like "p1a: Pikachu",
+// creating it if it wasn't seen yet.
+func (b *Battle) pokemon(id string) *BattlePokemon {
+	position, name := splitPokemonID(id)
+	if len(position) > 2 && name == "" {
+		// Only a position was given
+		if pokemon, ok := b.Active[position]; ok {
+			return pokemon
+		}
+	}
+	side := position
+	if len(side) > 2 {
+		side = side[:2]
+	}
+	key := side + ": " + name
+	pokemon, ok := b.Pokemon[key]
+	if !ok {
+		pokemon = &BattlePokemon{Name: name, Species: name, HP: 100, MaxHP: 100}
+		b.Pokemon[key] = pokemon
+	}
+	return pokemon
+}
+
+// splitPokemonID splits Pokémon ID, like "p1a: Pikachu", into a position
+// and a name.
+func splitPokemonID(id string) (string, string) {
+	parts := strings.SplitN(id, ": ", 2)
+	if len(parts) == 1 {
+		return strings.TrimSuffix(parts[0], ":"), ""
+	}
+	return parts[0], parts[1]
+}
+
+// setCondition parses a condition, like "55/100 par" or "0 fnt".
+func (p *BattlePokemon) setCondition(condition string) {
+	fields := strings.Fields(condition)
+	if len(fields) == 0 {
+		return
+	}
+	hp := strings.SplitN(fields[0], "/", 2)
+	p.HP, _ = strconv.Atoi(hp[0])
+	if len(hp) == 2 {
+		p.MaxHP, _ = strconv.Atoi(hp[1])
+	}
+	p.Status = ""
+	p.Fainted = false
+	if len(fields) > 1 {
+		if fields[1] == "fnt" {
+			p.Fainted = true
+		} else {
+			p.Status = fields[1]
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const battleProtocol = `|player|p1|Alice|1
+|player|p2|Bob|2
+|start
+|switch|p1a: Sparky|Pikachu, L50, M|100/100
+|switch|p2a: Charizard|Charizard, M|100/100
+|turn|1
+|move|p1a: Sparky|Thunderbolt|p2a: Charizard
+|-supereffective|p2a: Charizard
+|-damage|p2a: Charizard|55/100
+|move|p2a: Charizard|Swords Dance|p2a: Charizard
+|-boost|p2a: Charizard|atk|2
+|-status|p2a: Charizard|par
+|-damage|p2a: Charizard|43/100 par|[from] item: Life Orb
+|turn|2
+|move|p1a: Sparky|Thunderbolt|p2a: Charizard
+|-damage|p2a: Charizard|0 fnt
+|faint|p2a: Charizard
+|win|Alice`
+
+var battleLog = []BattleLine{
+	{"Battle started between Alice and Bob!", true, "start"},
+	{"Alice sent out Sparky (Pikachu)!", false, "switch"},
+	{"Bob sent out Charizard!", false, "switch"},
+	{"Turn 1", true, "turn"},
+	{"Sparky used Thunderbolt!", false, "move"},
+	{"It's super effective!", false, "-supereffective"},
+	{"Charizard lost 45% of its health!", false, "-damage"},
+	{"Charizard used Swords Dance!", false, "move"},
+	{"Charizard's Attack rose sharply!", false, "-boost"},
+	{"Charizard is paralyzed! It may be unable to move!", false, "-status"},
+	{"Charizard lost 12% of its health from Life Orb!", false, "-damage"},
+	{"Turn 2", true, "turn"},
+	{"Sparky used Thunderbolt!", false, "move"},
+	{"Charizard lost 43% of its health!", false, "-damage"},
+	{"Charizard fainted!", false, "faint"},
+	{"Alice won the battle!", true, "win"},
+}
+
+func playBattle(protocol string) *Battle {
+	battle := NewBattle()
+	for _, line := range strings.Split(protocol, "\n") {
+		parts := strings.SplitN(line[1:], "|", 2)
+		argument := ""
+		if len(parts) == 2 {
+			argument = parts[1]
+		}
+		battle.Update(parts[0], argument)
+	}
+	return battle
+}
+
+func TestBattle(t *testing.T) {
+	battle := playBattle(battleProtocol)
+	assert.Equal(t, battle.TakeLines(), battleLog, "battle log")
+	assert.Empty(t, battle.TakeLines(), "lines should be taken only once")
+	assert.Equal(t, battle.Turn, 2, "turn")
+	assert.Equal(t, battle.Winner, "Alice", "winner")
+	assert.Equal(t, battle.Active["p1a"].Species, "Pikachu", "species")
+	assert.True(t, battle.Pokemon["p2: Charizard"].Fainted, "Charizard should be fainted")
+}
+
+func TestMalformedSwitch(t *testing.T) {
+	battle := NewBattle()
+	assert.NotPanics(t, func() {
+		battle.Update("switch", "p|Pikachu, L50|100/100")
+		battle.Update("replace", ": Zoroark|Zoroark")
+	}, "malformed positions should be ignored")
+	assert.Empty(t, battle.Active, "no Pokémon should be active")
+	assert.Empty(t, battle.TakeLines(), "nothing should be written")
+}
+
+const battleRequest = `{"active":[{"moves":[{"move":"Thunderbolt","id":"thunderbolt","pp":15,"maxpp":15,"target":"normal","disabled":false},{"move":"Protect","id":"protect","pp":10,"maxpp":10,"target":"self","disabled":"Imprison"}]}],` +
+	`"side":{"name":"Alice","id":"p1","pokemon":[{"ident":"p1: Sparky","details":"Pikachu, L50, M","condition":"100/100","active":true},{"ident":"p1: Gyarados","details":"Gyarados, F","condition":"0 fnt","active":false}]},"rqid":3}`
+
+func TestBattleRequest(t *testing.T) {
+	battle := NewBattle()
+	battle.Update("request", battleRequest)
+	request := battle.Request()
+	assert.Equal(t, request.RequestID, 3, "request ID")
+	assert.Equal(t, request.Active[0].Moves[0].Disabled, RequestFlag(false), "Thunderbolt shouldn't be disabled")
+	assert.Equal(t, request.Active[0].Moves[1].Disabled, RequestFlag(true), "Protect should be disabled")
+	assert.Equal(t, request.Side.Pokemon[0].Name(), "Sparky", "name")
+	assert.Equal(t, request.Side.Pokemon[0].Species(), "Pikachu", "species")
+	assert.True(t, request.Side.Pokemon[1].Fainted(), "Gyarados should be fainted")
+}
+
+func TestReplay(t *testing.T) {
+	battle := playBattle("|init|battle\n|tier|[Gen 7] OU\n" + battleProtocol + "\n|request|{}")
+	assert.Equal(t, battle.Protocol(), strings.Split("|tier|[Gen 7] OU\n"+battleProtocol, "\n"), "protocol")
+	assert.Equal(t, battle.Format, "[Gen 7] OU", "format")
+	transcript := battle.Transcript()
+	assert.True(t, strings.HasPrefix(transcript, "Battle started between Alice and Bob!\nAlice sent out Sparky (Pikachu)!\n"), "transcript %#q", transcript)
+	assert.Contains(t, transcript, "\n\nTurn 1\n", "turns should be separated")
+
+	replay := battle.ReplayHTML("battle-gen7ou-1")
+	assert.Contains(t, replay, `<input type="hidden" name="replayid" value="gen7ou-1" />`, "replay ID")
+	assert.Contains(t, replay, `<title>[Gen 7] OU replay: Alice vs. Bob</title>`, "title")
+	assert.Contains(t, replay, "<script type=\"text/plain\" class=\"battle-log-data\">|tier|[Gen 7] OU\n|player|p1|Alice|1\n", "log")
+}
+
+func TestUploadReplay(t *testing.T) {
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte("success"))
+	}))
+	defer server.Close()
+	oldURL := ReplayUploadURL
+	ReplayUploadURL = server.URL
+	defer func() { ReplayUploadURL = oldURL }()
+
+	replay := ReplayData{ID: "gen7ou-1", Log: "|start", Password: "secret"}
+	address, err := UploadReplay(replay)
+	assert.NoError(t, err)
+	assert.Equal(t, address, "https://replay.pokemonshowdown.com/gen7ou-1-secretpw", "replay URL")
+	assert.Equal(t, form["log"], []string{"|start"}, "uploaded log")
+	assert.Equal(t, form["id"], []string{"gen7ou-1"}, "uploaded ID")
+}
+
+func TestTeamPreview(t *testing.T) {
+	battle := playBattle("|player|p1|Alice|1\n|player|p2|Bob|2\n|clearpoke\n|poke|p1|Pikachu, L50, M|item\n|poke|p1|Gyarados, F|\n|poke|p2|Charizard, M|item\n|teampreview")
+	assert.Equal(t, battle.Preview, map[string][]string{"p1": {"Pikachu", "Gyarados"}, "p2": {"Charizard"}}, "preview")
+	assert.Equal(t, battle.TakeLines(), []BattleLine{{"Team preview: Alice: Pikachu, Gyarados / Bob: Charizard", true, "teampreview"}}, "team preview line")
+}
+
+func TestConcurrentReplay(t *testing.T) {
+	battle := playBattle("|init|battle\n|tier|[Gen 7] OU\n" + battleProtocol)
+	protocol := battle.Protocol()
+	done := make(chan struct{})
+	go func() {
+		battle.Transcript()
+		battle.ReplayHTML("battle-gen7ou-1")
+		close(done)
+	}()
+	battle.Update("turn", "2")
+	<-done
+	assert.Equal(t, len(battle.Protocol()), len(protocol)+1, "protocol should grow")
+	assert.NotEqual(t, protocol[len(protocol)-1], "|turn|2", "copies shouldn't change")
+}
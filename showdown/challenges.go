@@ -0,0 +1,70 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import "encoding/json"
+
+// Challenges represents battle challenges sent to and by an user.
+type Challenges struct {
+	// Formats of incoming challenges, by challenger
+	From map[UserID]string `json:"challengesFrom"`
+	// Outgoing challenge, nil when there isn't one
+	To *Challenge `json:"challengeTo"`
+}
+
+// Challenge represents an outgoing challenge.
+type Challenge struct {
+	To     UserID `json:"to"`
+	Format string `json:"format"`
+}
+
+// Search represents ladder searches and battles of an user.
+type Search struct {
+	// Formats in which an user searches for a battle
+	Searching []string `json:"searching"`
+	// Titles of battles an user plays in, by room
+	Games map[RoomID]string `json:"games"`
+}
+
+// Challenges returns battle challenges, as last sent by the server.
+func (bc *BotConnection) Challenges() Challenges {
+	bc.stateMutex.RLock()
+	defer bc.stateMutex.RUnlock()
+	return bc.challenges
+}
+
+// Search returns ladder searches and battles, as last sent by the
+// server.
+func (bc *BotConnection) Search() Search {
+	bc.stateMutex.RLock()
+	defer bc.stateMutex.RUnlock()
+	return bc.search
+}
+
+func updateChallenges(rawMessage string, room *Room) {
+	var challenges Challenges
+	if err := json.Unmarshal([]byte(rawMessage), &challenges); err == nil {
+		room.BotConnection.challenges = challenges
+	}
+}
+
+func updateSearch(rawMessage string, room *Room) {
+	var search Search
+	if err := json.Unmarshal([]byte(rawMessage), &search); err == nil {
+		room.BotConnection.search = search
+	}
+}
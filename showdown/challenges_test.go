@@ -0,0 +1,33 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChallenges(t *testing.T) {
+	bc := &BotConnection{commandCallback: func(command, argument string, room *Room) {}}
+	bc.parseMessage(`|updatechallenges|{"challengesFrom":{"alice":"gen7ou"},"challengeTo":{"to":"bob","format":"gen7ubers"}}` + "\n" +
+		`|updatesearch|{"searching":["gen7randombattle"],"games":{"battle-gen7ou-1":"[Gen 7] OU"}}`)
+	assert.Equal(t, bc.Challenges().From, map[UserID]string{"alice": "gen7ou"}, "incoming challenges")
+	assert.Equal(t, bc.Challenges().To, &Challenge{"bob", "gen7ubers"}, "outgoing challenge")
+	assert.Equal(t, bc.Search().Searching, []string{"gen7randombattle"}, "searches")
+	assert.Equal(t, bc.Search().Games, map[RoomID]string{"battle-gen7ou-1": "[Gen 7] OU"}, "games")
+}
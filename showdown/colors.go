@@ -0,0 +1,129 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"sync"
+)
+
+var (
+	customColors      = map[UserID]UserID{}
+	customColorsMutex sync.RWMutex
+)
+
+// LoadCustomColors loads custom name colors from a JSON file, in the
+// format used by Showdown client configuration, that is an object
+// mapping user IDs to user IDs whose colors should be used instead.
+func LoadCustomColors(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var colors map[string]string
+	if err := json.Unmarshal(contents, &colors); err != nil {
+		return err
+	}
+	SetCustomColors(colors)
+	return nil
+}
+
+// SetCustomColors replaces custom name colors.
+func SetCustomColors(colors map[string]string) {
+	parsed := make(map[UserID]UserID, len(colors))
+	for name, replacement := range colors {
+		parsed[ToID(name)] = ToID(replacement)
+	}
+	customColorsMutex.Lock()
+	customColors = parsed
+	customColorsMutex.Unlock()
+}
+
+// NameColor computes the color in which Showdown client displays a name.
+// It's based on a hash of user ID, so that it doesn't change when
+// a user changes the case or punctuation of a name.
+func NameColor(name string) color.RGBA {
+	id := ToID(name)
+	customColorsMutex.RLock()
+	if replacement, ok := customColors[id]; ok {
+		id = replacement
+	}
+	customColorsMutex.RUnlock()
+
+	sum := md5.Sum([]byte(id))
+	hash := hex.EncodeToString(sum[:])
+	hashPart := func(start int) float64 {
+		value, _ := strconv.ParseUint(hash[start:start+4], 16, 16)
+		return float64(value)
+	}
+	h := math.Mod(hashPart(4), 360)
+	s := math.Mod(hashPart(0), 50) + 40
+	l := math.Floor(math.Mod(hashPart(8), 20) + 30)
+
+	// Lightness is adjusted, so that names stay readable on white
+	// background, as in Showdown client.
+	r, g, b := hslToRGB(h, s, l)
+	luminance := r*r*r*0.2126 + g*g*g*0.7152 + b*b*b*0.0722
+	modifier := (luminance - 0.2) * -150
+	if modifier > 18 {
+		modifier = (modifier - 18) * 2.5
+	} else if modifier < 0 {
+		modifier /= 3
+	} else {
+		modifier = 0
+	}
+	if distance := math.Min(math.Abs(180-h), math.Abs(240-h)); distance < 15 {
+		modifier += (15 - distance) / 3
+	}
+	l += modifier
+
+	r, g, b = hslToRGB(h, s, l)
+	return color.RGBA{toByte(r), toByte(g), toByte(b), 255}
+}
+
+// hslToRGB converts a color with hue in degrees and saturation and
+// lightness in percents into RGB components between 0 and 1.
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	c := (100 - math.Abs(2*l-100)) * s / 100 / 100
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l/100 - c/2
+	switch int(h / 60) {
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	case 5:
+		r, g, b = c, 0, x
+	default:
+		r, g, b = c, x, 0
+	}
+	return r + m, g + m, b + m
+}
+
+func toByte(component float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Floor(component*255+0.5))))
+}
@@ -55,10 +55,14 @@ func (c *connection) write(message string) {
 	c.writer <- writerMessage{websocket.TextMessage, []byte(message)}
 }
 
+// Showdown delays messages sent more often than this, and refuses them
+// when too many were delayed, so messages are sent no faster than that.
+const throttleDelay = 600 * time.Millisecond
+
 func (c *connection) startWriter() {
 	for message := range c.writer {
 		c.websocket.WriteMessage(message.messageType, message.contents)
-		time.Sleep(400 * time.Millisecond)
+		time.Sleep(throttleDelay)
 	}
 }
 
@@ -0,0 +1,250 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a message from a server, parsed into a typed value. Events
+// are passed to handlers added with AddEventHandlers.
+type Event interface {
+	// EventRoom returns a room in which an event happened.
+	EventRoom() *Room
+}
+
+// RoomEvent is a part of every event, which stores its room.
+type RoomEvent struct {
+	Room *Room
+}
+
+// EventRoom returns a room in which an event happened.
+func (e RoomEvent) EventRoom() *Room {
+	return e.Room
+}
+
+// ChatMessage is a message in a chat room.
+type ChatMessage struct {
+	RoomEvent
+	Author  User
+	Message string
+	// Time when a message was sent, zero when a server didn't say
+	Time time.Time
+}
+
+// PrivateMessage is a private message, sent or received by the user.
+type PrivateMessage struct {
+	RoomEvent
+	Author    User
+	Recipient User
+	Message   string
+}
+
+// Join is sent when a user joins a room.
+type Join struct {
+	RoomEvent
+	User User
+}
+
+// Leave is sent when a user leaves a room.
+type Leave struct {
+	RoomEvent
+	User User
+}
+
+// Rename is sent when a user in a room changes a name.
+type Rename struct {
+	RoomEvent
+	User  User
+	OldID UserID
+}
+
+// RawHTML is HTML content shown in a room.
+type RawHTML struct {
+	RoomEvent
+	HTML string
+}
+
+// Error is an error message, usually caused by the last command used in
+// a room.
+type Error struct {
+	RoomEvent
+	Message string
+}
+
+// Init is sent when the user joins a room.
+type Init struct {
+	RoomEvent
+	// Type of a room, chat or battle
+	Type string
+}
+
+// Deinit is sent when the user leaves a room.
+type Deinit struct {
+	RoomEvent
+}
+
+// Popup is a message shown in a popup.
+type Popup struct {
+	RoomEvent
+	Message string
+}
+
+// Notice is a line of plain text in a room.
+type Notice struct {
+	RoomEvent
+	Message string
+}
+
+// ParseEvent parses a protocol message into an event. It returns nil
+// for commands without a typed event.
+func ParseEvent(command, argument string, room *Room) Event {
+	base := RoomEvent{room}
+	switch command {
+	case "":
+		return Notice{base, argument}
+	case "c", "chat":
+		parts := strings.SplitN(argument, "|", 2)
+		if len(parts) == 2 {
+			return ChatMessage{base, SplitUser(parts[0]), parts[1], time.Time{}}
+		}
+	case "c:":
+		parts := strings.SplitN(argument, "|", 3)
+		if len(parts) == 3 {
+			timestamp, _ := strconv.ParseInt(parts[0], 10, 64)
+			return ChatMessage{base, SplitUser(parts[1]), parts[2], time.Unix(timestamp, 0)}
+		}
+	case "pm":
+		parts := strings.SplitN(argument, "|", 3)
+		if len(parts) == 3 {
+			return PrivateMessage{base, SplitUser(parts[0]), SplitUser(parts[1]), parts[2]}
+		}
+	case "j", "J", "join":
+		return Join{base, SplitUser(argument)}
+	case "l", "L", "leave":
+		return Leave{base, SplitUser(argument)}
+	case "n", "N", "name":
+		parts := strings.SplitN(argument, "|", 2)
+		if len(parts) == 2 {
+			return Rename{base, SplitUser(parts[0]), UserID(parts[1])}
+		}
+	case "raw", "html":
+		return RawHTML{base, argument}
+	case "error":
+		return Error{base, argument}
+	case "init":
+		return Init{base, argument}
+	case "deinit":
+		return Deinit{base}
+	case "popup":
+		return Popup{base, argument}
+	}
+	return nil
+}
+
+// EventHandlers receive events of their types. Handlers that are nil
+// are skipped.
+type EventHandlers struct {
+	ChatMessage    func(ChatMessage)
+	PrivateMessage func(PrivateMessage)
+	Join           func(Join)
+	Leave          func(Leave)
+	Rename         func(Rename)
+	RawHTML        func(RawHTML)
+	Error          func(Error)
+	Init           func(Init)
+	Deinit         func(Deinit)
+	Popup          func(Popup)
+	Notice         func(Notice)
+}
+
+// Dispatch passes an event to a handler of its type.
+func (h EventHandlers) Dispatch(event Event) {
+	switch event := event.(type) {
+	case ChatMessage:
+		if h.ChatMessage != nil {
+			h.ChatMessage(event)
+		}
+	case PrivateMessage:
+		if h.PrivateMessage != nil {
+			h.PrivateMessage(event)
+		}
+	case Join:
+		if h.Join != nil {
+			h.Join(event)
+		}
+	case Leave:
+		if h.Leave != nil {
+			h.Leave(event)
+		}
+	case Rename:
+		if h.Rename != nil {
+			h.Rename(event)
+		}
+	case RawHTML:
+		if h.RawHTML != nil {
+			h.RawHTML(event)
+		}
+	case Error:
+		if h.Error != nil {
+			h.Error(event)
+		}
+	case Init:
+		if h.Init != nil {
+			h.Init(event)
+		}
+	case Deinit:
+		if h.Deinit != nil {
+			h.Deinit(event)
+		}
+	case Popup:
+		if h.Popup != nil {
+			h.Popup(event)
+		}
+	case Notice:
+		if h.Notice != nil {
+			h.Notice(event)
+		}
+	}
+}
+
+// AddEventHandlers adds handlers of typed events. Events are passed to
+// them before the raw callback given when connecting, which still
+// receives every message, including those without typed events.
+// Handlers only receive messages that arrive after they are added, so
+// handlers which cannot miss any message should be given when
+// connecting instead.
+func (bc *BotConnection) AddEventHandlers(handlers EventHandlers) {
+	bc.eventMutex.Lock()
+	bc.eventHandlers = append(bc.eventHandlers, handlers)
+	bc.eventMutex.Unlock()
+}
+
+func (bc *BotConnection) dispatchEvent(command, argument string, room *Room) {
+	event := ParseEvent(command, argument, room)
+	if event == nil {
+		return
+	}
+	bc.eventMutex.RLock()
+	handlers := bc.eventHandlers
+	bc.eventMutex.RUnlock()
+	for _, handler := range handlers {
+		handler.Dispatch(event)
+	}
+}
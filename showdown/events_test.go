@@ -0,0 +1,67 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEvent(t *testing.T) {
+	room := &Room{ID: "lobby"}
+	base := RoomEvent{room}
+	tests := []struct {
+		command, argument string
+		event             Event
+	}{
+		{"c:", "1500000000|+Alice|hi | there", ChatMessage{base, User{'+', "Alice"}, "hi | there", time.Unix(1500000000, 0)}},
+		{"c", " Bob|hello", ChatMessage{base, User{' ', "Bob"}, "hello", time.Time{}}},
+		{"pm", " Alice| Bob|/me waves", PrivateMessage{base, User{' ', "Alice"}, User{' ', "Bob"}, "/me waves"}},
+		{"J", "@Carol", Join{base, User{'@', "Carol"}}},
+		{"l", " Carol", Leave{base, User{' ', "Carol"}}},
+		{"N", " Caroline|carol", Rename{base, User{' ', "Caroline"}, "carol"}},
+		{"raw", "<b>Hi</b>", RawHTML{base, "<b>Hi</b>"}},
+		{"error", "Access denied", Error{base, "Access denied"}},
+		{"init", "battle", Init{base, "battle"}},
+		{"deinit", "", Deinit{base}},
+		{"popup", "Hello", Popup{base, "Hello"}},
+		{"", "Plain text", Notice{base, "Plain text"}},
+		{"c:", "broken", nil},
+		{"unknown", "", nil},
+	}
+	for _, test := range tests {
+		assert.Equal(t, ParseEvent(test.command, test.argument, room), test.event, "ParseEvent(%#q, %#q)", test.command, test.argument)
+	}
+}
+
+func TestEventHandlers(t *testing.T) {
+	var raw []string
+	var messages []string
+	bc := &BotConnection{commandCallback: func(command, argument string, room *Room) {
+		raw = append(raw, command)
+	}}
+	bc.AddEventHandlers(EventHandlers{
+		ChatMessage: func(event ChatMessage) {
+			messages = append(messages, event.Author.Name+": "+event.Message)
+		},
+	})
+	bc.parseMessage("|c:|1|+Alice|hi\n|c| Bob|hello")
+	assert.Equal(t, messages, []string{"Alice: hi", "Bob: hello"}, "chat messages")
+	assert.Equal(t, raw, []string{"c:", "c"}, "the raw callback should receive every message")
+}
@@ -0,0 +1,150 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Flags of a format in |formats| message
+const (
+	formatNoTeam        = 1
+	formatSearchable    = 2
+	formatChallengeable = 4
+	formatTournament    = 8
+	formatLevel50       = 16
+)
+
+// Format describes a battle format available on a server.
+type Format struct {
+	ID      string
+	Name    string
+	Section string
+	// Column of the format list in which the section is displayed
+	Column int
+	// Whether a format needs a team, random battles don't
+	TeamRequired  bool
+	Searchable    bool
+	Challengeable bool
+	Tournament    bool
+	// Whether the teambuilder should use level 50 by default
+	Level50 bool
+}
+
+// Formats is a catalog of formats, in order given by the server.
+type Formats []Format
+
+// Find returns a format with a given name or ID.
+func (f Formats) Find(name string) (Format, bool) {
+	id := string(ToID(name))
+	for _, format := range f {
+		if format.ID == id {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+// Sections returns names of sections, in order given by the server.
+func (f Formats) Sections() []string {
+	var sections []string
+	for i, format := range f {
+		if i == 0 || f[i-1].Section != format.Section {
+			sections = append(sections, format.Section)
+		}
+	}
+	return sections
+}
+
+// Search returns formats whose name or section contains a query. Case
+// and punctuation are ignored.
+func (f Formats) Search(query string) Formats {
+	id := string(ToID(query))
+	var result Formats
+	for _, format := range f {
+		if strings.Contains(format.ID, id) || strings.Contains(string(ToID(format.Section)), id) {
+			result = append(result, format)
+		}
+	}
+	return result
+}
+
+// parseFormats parses |formats| message. Sections start with an entry
+// containing a column number, like ",1", followed by the section name.
+// Other entries are format names, followed by hexadecimal flags.
+func parseFormats(rawMessage string) Formats {
+	var formats Formats
+	section := ""
+	column := 0
+	isSection := false
+	for _, entry := range strings.Split(rawMessage, "|") {
+		if isSection {
+			section = entry
+			isSection = false
+			continue
+		}
+		if entry == "" {
+			isSection = true
+			continue
+		}
+		if entry[0] == ',' {
+			// Other entries starting with a comma, like ",LL", announce
+			// protocol features.
+			if number, err := strconv.Atoi(entry[1:]); err == nil {
+				isSection = true
+				column = number
+			}
+			continue
+		}
+		formats = append(formats, parseFormat(entry, section, column))
+	}
+	return formats
+}
+
+func parseFormat(entry, section string, column int) Format {
+	name := entry
+	code := formatSearchable | formatChallengeable | formatTournament
+	if comma := strings.LastIndex(entry, ","); comma >= 0 {
+		if flags, err := strconv.ParseInt(entry[comma+1:], 16, 0); err == nil {
+			name = entry[:comma]
+			code = int(flags)
+		}
+	}
+	return Format{
+		ID:            string(ToID(name)),
+		Name:          name,
+		Section:       section,
+		Column:        column,
+		TeamRequired:  code&formatNoTeam == 0,
+		Searchable:    code&formatSearchable != 0,
+		Challengeable: code&formatChallengeable != 0,
+		Tournament:    code&formatTournament != 0,
+		Level50:       code&formatLevel50 != 0,
+	}
+}
+
+// Formats returns formats available on the server.
+func (bc *BotConnection) Formats() Formats {
+	bc.stateMutex.RLock()
+	defer bc.stateMutex.RUnlock()
+	return bc.formats
+}
+
+func updateFormats(rawMessage string, room *Room) {
+	room.BotConnection.formats = parseFormats(rawMessage)
+}
@@ -0,0 +1,50 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormats(t *testing.T) {
+	bc := &BotConnection{commandCallback: func(command, argument string, room *Room) {}}
+	bc.parseMessage("|formats|,LL|,1|S/V Singles|[Gen 9] Random Battle,f|[Gen 9] OU,e|,2|S/V Doubles|[Gen 9] VGC 2024,1c")
+	formats := bc.Formats()
+	assert.Equal(t, len(formats), 3, "number of formats")
+	assert.Equal(t, formats[0], Format{
+		ID:            "gen9randombattle",
+		Name:          "[Gen 9] Random Battle",
+		Section:       "S/V Singles",
+		Column:        1,
+		Searchable:    true,
+		Challengeable: true,
+		Tournament:    true,
+	}, "random battle")
+	vgc, ok := formats.Find("[Gen 9] VGC 2024")
+	assert.True(t, ok, "Find should find a format by name")
+	assert.True(t, vgc.TeamRequired, "VGC requires a team")
+	assert.False(t, vgc.Searchable, "VGC isn't searchable")
+	assert.True(t, vgc.Level50, "VGC uses level 50")
+	assert.Equal(t, vgc.Column, 2, "VGC column")
+	assert.Equal(t, formats.Sections(), []string{"S/V Singles", "S/V Doubles"}, "sections")
+	assert.Equal(t, len(formats.Search("doubles")), 1, "search by section")
+	assert.Equal(t, len(formats.Search("gen9")), 3, "search by name")
+	_, ok = formats.Find("gen1ou")
+	assert.False(t, ok, "Find shouldn't find unknown formats")
+}
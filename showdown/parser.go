@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 )
 
 // LoginData represents authentication information for a bot
@@ -35,10 +36,25 @@ type LoginData struct {
 
 // BotConnection represents a websocket communication with a bot
 type BotConnection struct {
-	loginData       LoginData
-	rooms           map[RoomID]*Room
+	loginData LoginData
+	rooms     map[RoomID]*Room
+	// Guards rooms and state changed by server commands, which is
+	// read by other goroutines than the one handling messages
+	stateMutex      sync.RWMutex
 	commandCallback func(command, argument string, room *Room)
 	onSuccess       chan<- struct{}
+	// Battle challenges, as last sent by the server
+	challenges Challenges
+	// Ladder searches and battles, as last sent by the server
+	search Search
+	// Formats available on the server
+	formats Formats
+	// Queries waiting for a response, by type
+	queries    map[string][]*pendingQuery
+	queryMutex sync.Mutex
+	// Handlers of typed events
+	eventHandlers []EventHandlers
+	eventMutex    sync.RWMutex
 	*connection
 }
 
@@ -50,12 +66,45 @@ type BotConnection struct {
 //
 // This command doesn't handle room aliases.
 func (bc *BotConnection) Room(id RoomID) *Room {
+	bc.stateMutex.RLock()
+	defer bc.stateMutex.RUnlock()
 	if roomWithUsers, ok := bc.rooms[id]; ok {
 		return roomWithUsers
 	}
 	return &Room{BotConnection: bc, ID: id}
 }
 
+// InRoom checks whether an user is currently in a room.
+func (bc *BotConnection) InRoom(id RoomID) bool {
+	bc.stateMutex.RLock()
+	defer bc.stateMutex.RUnlock()
+	_, ok := bc.rooms[id]
+	return ok
+}
+
+// JoinedRoom finds a room an user is in by a name given by an user,
+// which gets normalized first. As the server doesn't say which room a
+// message is for when it's sent to the lobby, the lobby is found both
+// by "lobby" and an empty name.
+func (bc *BotConnection) JoinedRoom(name string) (*Room, bool) {
+	bc.stateMutex.RLock()
+	defer bc.stateMutex.RUnlock()
+	id := ToRoomID(name)
+	if room, ok := bc.rooms[id]; ok {
+		return room, true
+	}
+	switch id {
+	case "":
+		id = "lobby"
+	case "lobby":
+		id = ""
+	default:
+		return nil, false
+	}
+	room, ok := bc.rooms[id]
+	return room, ok
+}
+
 func (bc *BotConnection) parseMessage(message string) {
 	var roomID RoomID
 	if message[0] == '>' {
@@ -79,8 +128,13 @@ func (bc *BotConnection) parseMessage(message string) {
 			if handler, ok := serverCommandHandlers[command]; ok {
 				handler(argument, bc.Room(roomID))
 			}
+			if battle := bc.Room(roomID).Battle; battle != nil {
+				battle.Update(command, argument)
+			}
+			bc.dispatchEvent(command, argument, bc.Room(roomID))
 			bc.commandCallback(command, argument, bc.Room(roomID))
 		} else {
+			bc.dispatchEvent("", currentMessage, bc.Room(roomID))
 			bc.commandCallback("", currentMessage, bc.Room(roomID))
 		}
 		if len(messages) != 2 {
@@ -109,12 +163,7 @@ func (bc *BotConnection) say(message string, room RoomID) {
 	if message == "" {
 		return
 	}
-	if message[0] == '/' {
-		message = "/" + message
-	} else if message[0] == '!' || strings.HasPrefix(message, ">> ") || strings.HasPrefix(message, ">>> ") {
-		message = " " + message
-	}
-	bc.write(fmt.Sprintf("%s|%s", room, message))
+	bc.write(fmt.Sprintf("%s|%s", room, EscapeMessage(message)))
 }
 
 func handleConnection(botConnection *BotConnection) {
@@ -125,18 +174,19 @@ func handleConnection(botConnection *BotConnection) {
 }
 
 // ConnectToServer connects to a Showdown server by using its
-// client location or its name.
-func ConnectToServer(loginData LoginData, name string, commandCallback func(command, argument string, room *Room)) (*BotConnection, <-chan struct{}, error) {
+// client location or its name. Event handlers given here receive
+// every message, unlike ones added with AddEventHandlers later.
+func ConnectToServer(loginData LoginData, name string, commandCallback func(command, argument string, room *Room), handlers ...EventHandlers) (*BotConnection, <-chan struct{}, error) {
 	conf, err := findConfiguration(name)
 	if err != nil {
 		return nil, nil, err
 	}
-	return ConnectToKnownServer(loginData, conf, commandCallback)
+	return ConnectToKnownServer(loginData, conf, commandCallback, handlers...)
 }
 
 // ConnectToKnownServer connects to a Showdown server with known
 // configuration.
-func ConnectToKnownServer(loginData LoginData, conf ServerAddress, commandCallback func(command, argument string, room *Room)) (*BotConnection, <-chan struct{}, error) {
+func ConnectToKnownServer(loginData LoginData, conf ServerAddress, commandCallback func(command, argument string, room *Room), handlers ...EventHandlers) (*BotConnection, <-chan struct{}, error) {
 	connection, err := webSocketConnect(&conf)
 	if err != nil {
 		return nil, nil, err
@@ -148,21 +198,28 @@ func ConnectToKnownServer(loginData LoginData, conf ServerAddress, commandCallba
 		rooms:           map[RoomID]*Room{},
 		commandCallback: commandCallback,
 		onSuccess:       onSuccess,
+		eventHandlers:   handlers,
 	}
 	go handleConnection(botConnection)
 	return botConnection, onSuccess, nil
 }
 
 var serverCommandHandlers = map[string]func(string, *Room){
-	"challstr": challStr,
-	"init":     initializeChatRoom,
-	"title":    setTitle,
-	"users":    setUsers,
-	"j":        joinRoom,
-	"J":        joinRoom,
-	"l":        leaveRoom,
-	"L":        leaveRoom,
-	"N":        renameNick,
+	"challstr":         challStr,
+	"init":             locked(initializeChatRoom),
+	"deinit":           locked(deinitializeChatRoom),
+	"title":            setTitle,
+	"users":            setUsers,
+	"j":                joinRoom,
+	"J":                joinRoom,
+	"l":                leaveRoom,
+	"L":                leaveRoom,
+	"N":                renameNick,
+	"updatechallenges": locked(updateChallenges),
+	"updatesearch":     locked(updateSearch),
+	"formats":          locked(updateFormats),
+	"tournament":       locked(updateTournament),
+	"queryresponse":    queryResponse,
 }
 
 const actionURL = "https://play.pokemonshowdown.com/action.php"
@@ -202,10 +259,28 @@ func challStr(challenge string, room *Room) {
 		botConnection.SendGlobalCommand("join", room)
 	}
 }
+
+// locked makes a handler hold a lock while it changes state which is
+// read by other goroutines.
+func locked(handler func(string, *Room)) func(string, *Room) {
+	return func(rawMessage string, room *Room) {
+		room.BotConnection.stateMutex.Lock()
+		defer room.BotConnection.stateMutex.Unlock()
+		handler(rawMessage, room)
+	}
+}
+
 func initializeChatRoom(rawMessage string, room *Room) {
+	if rawMessage == "battle" {
+		room.Battle = NewBattle()
+	}
 	room.BotConnection.rooms[room.ID] = room
 }
 
+func deinitializeChatRoom(rawMessage string, room *Room) {
+	delete(room.BotConnection.rooms, room.ID)
+}
+
 func setTitle(rawMessage string, room *Room) {
 	room.Title = rawMessage
 }
@@ -0,0 +1,236 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// QueryTimeout limits how long queries wait for a response, in case
+// a context doesn't have an earlier deadline.
+var QueryTimeout = 10 * time.Second
+
+// ErrQueryTimeout is returned when the server doesn't respond to
+// a query in time.
+var ErrQueryTimeout = errors.New("the server didn't respond to the query")
+
+// pendingQuery waits for a |queryresponse| message of its type. The
+// server doesn't say which query a response is for, so responses are
+// given to the oldest query they match.
+type pendingQuery struct {
+	matches  func(response json.RawMessage) bool
+	response chan json.RawMessage
+}
+
+// Query sends a /cmd query, and decodes a response into result. Only
+// responses accepted by matches are considered, nil matches every
+// response of a type.
+func (bc *BotConnection) Query(ctx context.Context, queryType, argument string, matches func(json.RawMessage) bool, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+	query := &pendingQuery{matches, make(chan json.RawMessage, 1)}
+
+	bc.queryMutex.Lock()
+	if bc.queries == nil {
+		bc.queries = map[string][]*pendingQuery{}
+	}
+	bc.queries[queryType] = append(bc.queries[queryType], query)
+	bc.queryMutex.Unlock()
+
+	bc.SendGlobalCommand("cmd", queryType+" "+argument)
+	select {
+	case response := <-query.response:
+		return json.Unmarshal(response, result)
+	case <-ctx.Done():
+		bc.removeQuery(queryType, query)
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrQueryTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+func (bc *BotConnection) removeQuery(queryType string, query *pendingQuery) {
+	bc.queryMutex.Lock()
+	defer bc.queryMutex.Unlock()
+	queries := bc.queries[queryType]
+	for i, pending := range queries {
+		if pending == query {
+			bc.queries[queryType] = append(queries[:i], queries[i+1:]...)
+			return
+		}
+	}
+}
+
+// resolveQuery gives a response to the oldest query that matches it.
+func (bc *BotConnection) resolveQuery(queryType string, response json.RawMessage) {
+	bc.queryMutex.Lock()
+	defer bc.queryMutex.Unlock()
+	queries := bc.queries[queryType]
+	for i, query := range queries {
+		if query.matches == nil || query.matches(response) {
+			bc.queries[queryType] = append(queries[:i], queries[i+1:]...)
+			query.response <- response
+			return
+		}
+	}
+}
+
+func queryResponse(rawMessage string, room *Room) {
+	parts := strings.SplitN(rawMessage, "|", 2)
+	if len(parts) == 2 {
+		room.BotConnection.resolveQuery(parts[0], json.RawMessage(parts[1]))
+	}
+}
+
+// UserDetails describes a user, as returned by QueryUserDetails.
+type UserDetails struct {
+	ID     UserID `json:"userid"`
+	Name   string `json:"name"`
+	Group  string `json:"group"`
+	Status string `json:"status"`
+	// Ranks in rooms a user is in, nil when a user is offline
+	Rooms         UserRooms `json:"rooms"`
+	Autoconfirmed bool      `json:"autoconfirmed"`
+}
+
+// Online checks whether a user is connected.
+func (d UserDetails) Online() bool {
+	return d.Rooms != nil
+}
+
+// UserRooms are ranks of a user in rooms, space for users without
+// a rank.
+type UserRooms map[RoomID]rune
+
+// UnmarshalJSON parses rooms, which are an object whose keys are room
+// IDs preceded by a rank, or false for offline users.
+func (r *UserRooms) UnmarshalJSON(data []byte) error {
+	if string(data) == "false" || string(data) == "null" {
+		*r = nil
+		return nil
+	}
+	var rooms map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rooms); err != nil {
+		return err
+	}
+	*r = UserRooms{}
+	for name := range rooms {
+		rank := ' '
+		if first, size := utf8.DecodeRuneInString(name); name != "" && ToID(name[:size]) == "" {
+			rank = first
+			name = name[size:]
+		}
+		(*r)[RoomID(name)] = rank
+	}
+	return nil
+}
+
+// QueryUserDetails asks about a user.
+func (bc *BotConnection) QueryUserDetails(ctx context.Context, name string) (UserDetails, error) {
+	id := ToID(name)
+	var details UserDetails
+	err := bc.Query(ctx, "userdetails", string(id), func(response json.RawMessage) bool {
+		var user struct {
+			ID UserID `json:"userid"`
+		}
+		return json.Unmarshal(response, &user) == nil && user.ID == id
+	}, &details)
+	return details, err
+}
+
+// RoomsInfo lists public chat rooms, as returned by QueryRooms.
+type RoomsInfo struct {
+	Official    []RoomInfo `json:"official"`
+	PSPL        []RoomInfo `json:"pspl"`
+	Chat        []RoomInfo `json:"chat"`
+	UserCount   int        `json:"userCount"`
+	BattleCount int        `json:"battleCount"`
+}
+
+// RoomInfo describes a chat room.
+type RoomInfo struct {
+	Title       string   `json:"title"`
+	Description string   `json:"desc"`
+	UserCount   int      `json:"userCount"`
+	Section     string   `json:"section"`
+	SubRooms    []string `json:"subRooms"`
+}
+
+// All returns all rooms, official rooms first.
+func (r RoomsInfo) All() []RoomInfo {
+	return append(append(append([]RoomInfo{}, r.Official...), r.PSPL...), r.Chat...)
+}
+
+// QueryRooms asks for a list of public chat rooms.
+func (bc *BotConnection) QueryRooms(ctx context.Context) (RoomsInfo, error) {
+	var rooms RoomsInfo
+	err := bc.Query(ctx, "rooms", "", nil, &rooms)
+	return rooms, err
+}
+
+// BattleInfo describes a battle, as returned by QueryRoomList.
+type BattleInfo struct {
+	Player1 string `json:"p1"`
+	Player2 string `json:"p2"`
+}
+
+// QueryRoomList asks for a list of recent battles, in a given format,
+// or in all formats when the format is empty.
+func (bc *BotConnection) QueryRoomList(ctx context.Context, format string) (map[RoomID]BattleInfo, error) {
+	var list struct {
+		Rooms map[RoomID]BattleInfo `json:"rooms"`
+	}
+	err := bc.Query(ctx, "roomlist", string(ToID(format)), nil, &list)
+	return list.Rooms, err
+}
+
+// LadderSearch lists users on a ladder whose names start with a prefix,
+// as returned by QueryLaddersearch.
+type LadderSearch struct {
+	Format string       `json:"format"`
+	Prefix string       `json:"target"`
+	Users  []LadderUser `json:"result"`
+}
+
+// LadderUser describes a rating of a user on a ladder.
+type LadderUser struct {
+	ID     UserID  `json:"userid"`
+	Name   string  `json:"username"`
+	Elo    float64 `json:"elo"`
+	GXE    float64 `json:"gxe"`
+	Wins   int     `json:"w"`
+	Losses int     `json:"l"`
+	Ties   int     `json:"t"`
+}
+
+// QueryLaddersearch asks for ratings of users on a ladder of a format,
+// or for top users when the prefix is empty.
+func (bc *BotConnection) QueryLaddersearch(ctx context.Context, format, prefix string) (LadderSearch, error) {
+	formatID, prefixID := ToID(format), ToID(prefix)
+	var search LadderSearch
+	err := bc.Query(ctx, "laddersearch", string(formatID)+","+string(prefixID), func(response json.RawMessage) bool {
+		var result LadderSearch
+		return json.Unmarshal(response, &result) == nil && ToID(result.Format) == formatID && ToID(result.Prefix) == prefixID
+	}, &search)
+	return search, err
+}
@@ -0,0 +1,104 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueryConnection() *BotConnection {
+	return &BotConnection{
+		commandCallback: func(command, argument string, room *Room) {},
+		connection:      &connection{writer: make(chan writerMessage, 10)},
+	}
+}
+
+func TestQueryUserDetails(t *testing.T) {
+	bc := newQueryConnection()
+	type result struct {
+		details UserDetails
+		err     error
+	}
+	results := make(chan result)
+	for _, name := range []string{"Alice", "Bob"} {
+		go func(name string) {
+			details, err := bc.QueryUserDetails(context.Background(), name)
+			results <- result{details, err}
+		}(name)
+		message := <-bc.writer
+		assert.Equal(t, string(message.contents), "|/cmd userdetails "+string(ToID(name)), "query")
+	}
+	// Responses are given to queries they are for, even when they are
+	// sent in a different order.
+	bc.parseMessage(`|queryresponse|userdetails|{"userid":"bob","name":"Bob","group":" ","rooms":false}`)
+	bob := <-results
+	assert.NoError(t, bob.err)
+	assert.Equal(t, bob.details.Name, "Bob", "name")
+	assert.False(t, bob.details.Online(), "Bob should be offline")
+
+	bc.parseMessage(`|queryresponse|userdetails|{"userid":"alice","name":"Alice","group":"+","rooms":{"@lobby":{},"battle-gen9ou-1":{},"☆battle-gen9ou-2":{}}}`)
+	alice := <-results
+	assert.NoError(t, alice.err)
+	assert.True(t, alice.details.Online(), "Alice should be online")
+	assert.Equal(t, alice.details.Rooms, UserRooms{"lobby": '@', "battle-gen9ou-1": ' ', "battle-gen9ou-2": '☆'}, "rooms")
+}
+
+func TestQueryRooms(t *testing.T) {
+	bc := newQueryConnection()
+	go func() {
+		<-bc.writer
+		bc.parseMessage(`|queryresponse|rooms|{"official":[{"title":"Lobby","desc":"Chat","userCount":100}],"chat":[{"title":"Tech & Code","desc":"Programming","userCount":20}],"userCount":500,"battleCount":50}`)
+	}()
+	rooms, err := bc.QueryRooms(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, rooms.All(), []RoomInfo{{Title: "Lobby", Description: "Chat", UserCount: 100}, {Title: "Tech & Code", Description: "Programming", UserCount: 20}}, "rooms")
+	assert.Equal(t, rooms.BattleCount, 50, "battle count")
+}
+
+func TestQueryLaddersearch(t *testing.T) {
+	bc := newQueryConnection()
+	go func() {
+		message := <-bc.writer
+		assert.Equal(t, string(message.contents), "|/cmd laddersearch gen9ou,ali", "query")
+		// A response to another search shouldn't be taken
+		bc.parseMessage(`|queryresponse|laddersearch|{"format":"gen9ou","target":"bob","result":[]}`)
+		bc.parseMessage(`|queryresponse|laddersearch|{"format":"gen9ou","target":"ali","result":[{"userid":"alice","username":"Alice","elo":1500.5,"gxe":70.1,"w":10,"l":5,"t":1}]}`)
+	}()
+	search, err := bc.QueryLaddersearch(context.Background(), "[Gen 9] OU", "Ali")
+	assert.NoError(t, err)
+	assert.Equal(t, search.Format, "gen9ou", "format")
+	assert.Equal(t, search.Users, []LadderUser{{ID: "alice", Name: "Alice", Elo: 1500.5, GXE: 70.1, Wins: 10, Losses: 5, Ties: 1}}, "users")
+}
+
+func TestQueryTimeout(t *testing.T) {
+	bc := newQueryConnection()
+	oldTimeout := QueryTimeout
+	QueryTimeout = 10 * time.Millisecond
+	defer func() { QueryTimeout = oldTimeout }()
+	_, err := bc.QueryRoomList(context.Background(), "gen9ou")
+	assert.Equal(t, err, ErrQueryTimeout, "error")
+	assert.Empty(t, bc.queries["roomlist"], "timed out queries should be removed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = bc.QueryRooms(ctx)
+	assert.Equal(t, err, context.Canceled, "error of a canceled query")
+}
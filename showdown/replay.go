@@ -0,0 +1,137 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ReplayUploadURL is an endpoint to which replays saved with /savereplay
+// are uploaded.
+var ReplayUploadURL = actionURL + "?act=uploadreplay"
+
+// ReplayViewURL is an address under which uploaded replays are shown.
+var ReplayViewURL = "https://replay.pokemonshowdown.com/"
+
+// Commands that don't appear in replays, either because they manage
+// rooms, or because they are only sent to a player
+var unrecordedCommands = map[string]bool{
+	"init":    true,
+	"deinit":  true,
+	"noinit":  true,
+	"request": true,
+}
+
+// Protocol returns a copy of protocol messages of a battle, as they
+// appear in a replay.
+func (b *Battle) Protocol() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string(nil), b.protocol...)
+}
+
+// Transcript returns the text log of a battle, with turns separated by
+// empty lines.
+func (b *Battle) Transcript() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var buffer bytes.Buffer
+	for i, line := range b.log {
+		if line.Heading && i != 0 {
+			buffer.WriteByte('\n')
+		}
+		buffer.WriteString(line.Text)
+		buffer.WriteByte('\n')
+	}
+	return buffer.String()
+}
+
+// ReplayHTML creates a replay file, in the same format as replays
+// downloaded with Showdown client. The file uses a script from Showdown
+// to display the battle.
+func (b *Battle) ReplayHTML(room RoomID) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var buffer bytes.Buffer
+	p1, p2 := b.Players["p1"], b.Players["p2"]
+	fmt.Fprintf(&buffer, "<!DOCTYPE html>\n<meta charset=\"utf-8\" />\n<!-- version 1 -->\n")
+	fmt.Fprintf(&buffer, "<title>%s</title>\n", html.EscapeString(fmt.Sprintf("%s replay: %s vs. %s", b.Format, p1, p2)))
+	fmt.Fprintf(&buffer, "<div class=\"wrapper replay-wrapper\" style=\"max-width:1180px;margin:0 auto\">\n")
+	fmt.Fprintf(&buffer, "<input type=\"hidden\" name=\"replayid\" value=\"%s\" />\n", html.EscapeString(strings.TrimPrefix(string(room), "battle-")))
+	fmt.Fprintf(&buffer, "<div class=\"battle\"></div><div class=\"battle-log\"></div><div class=\"replay-controls\"></div><div class=\"replay-controls-2\"></div>\n")
+	fmt.Fprintf(&buffer, "<h1 style=\"font-weight:normal;text-align:center\"><strong>%s</strong><br />%s vs. %s</h1>\n",
+		html.EscapeString(b.Format), replayPlayerLink(p1), replayPlayerLink(p2))
+	// Slashes are escaped, so that the log cannot close the script
+	fmt.Fprintf(&buffer, "<script type=\"text/plain\" class=\"battle-log-data\">%s</script>\n",
+		strings.Replace(strings.Join(b.protocol, "\n"), "/", `\/`, -1))
+	fmt.Fprintf(&buffer, "</div>\n<script>\n")
+	fmt.Fprintf(&buffer, "let daily = Math.floor(Date.now()/1000/60/60/24);document.write('<script src=\"https://play.pokemonshowdown.com/js/replay-embed.js?version'+daily+'\"></'+'script>');\n")
+	fmt.Fprintf(&buffer, "</script>\n")
+	return buffer.String()
+}
+
+func replayPlayerLink(name string) string {
+	return fmt.Sprintf(`<a href="https://pokemonshowdown.com/users/%s" class="subtle" target="_blank">%s</a>`, ToID(name), html.EscapeString(name))
+}
+
+// ReplayData is sent by the server in response to /savereplay command.
+type ReplayData struct {
+	ID  string `json:"id"`
+	Log string `json:"log"`
+	// Password of a private replay, empty for public replays
+	Password string `json:"password"`
+	// Whether the replay was saved automatically, and shouldn't be
+	// announced
+	Silent bool `json:"silent"`
+}
+
+// URL returns an address under which a replay is shown once uploaded.
+func (r ReplayData) URL() string {
+	if r.Password != "" {
+		return ReplayViewURL + r.ID + "-" + r.Password + "pw"
+	}
+	return ReplayViewURL + r.ID
+}
+
+// UploadReplay uploads a replay to ReplayUploadURL, returning its
+// address.
+func UploadReplay(replay ReplayData) (string, error) {
+	parameters := url.Values{}
+	parameters.Set("id", replay.ID)
+	parameters.Set("log", replay.Log)
+	parameters.Set("password", replay.Password)
+	res, err := http.PostForm(ReplayUploadURL, parameters)
+	if err != nil {
+		return "", err
+	}
+	contents, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if response := strings.TrimSpace(string(contents)); !strings.HasPrefix(response, "success") {
+		return "", errors.New("replay upload failed: " + response)
+	}
+	return replay.URL(), nil
+}
@@ -0,0 +1,119 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// BattleRequest is a request for a decision in a battle, sent to its
+// players.
+type BattleRequest struct {
+	// Active Pokémon, with moves they can use
+	Active []ActiveRequest `json:"active"`
+	Side   SideRequest     `json:"side"`
+	// Positions of active Pokémon that need to be switched out
+	ForceSwitch []bool `json:"forceSwitch"`
+	TeamPreview bool   `json:"teamPreview"`
+	// The opponent needs to make a decision
+	Wait      bool `json:"wait"`
+	RequestID int  `json:"rqid"`
+}
+
+// ActiveRequest describes options of an active Pokémon.
+type ActiveRequest struct {
+	Moves         []MoveRequest `json:"moves"`
+	Trapped       bool          `json:"trapped"`
+	CanMegaEvolve bool          `json:"canMegaEvo"`
+}
+
+// MoveRequest describes a move that an active Pokémon knows.
+type MoveRequest struct {
+	Move     string      `json:"move"`
+	ID       string      `json:"id"`
+	PP       int         `json:"pp"`
+	MaxPP    int         `json:"maxpp"`
+	Target   string      `json:"target"`
+	Disabled RequestFlag `json:"disabled"`
+}
+
+// SideRequest describes a side of a player.
+type SideRequest struct {
+	Name    string        `json:"name"`
+	ID      string        `json:"id"`
+	Pokemon []SidePokemon `json:"pokemon"`
+}
+
+// SidePokemon describes a Pokémon in a team of a player.
+type SidePokemon struct {
+	Ident     string   `json:"ident"`
+	Details   string   `json:"details"`
+	Condition string   `json:"condition"`
+	Active    bool     `json:"active"`
+	Moves     []string `json:"moves"`
+	Item      string   `json:"item"`
+	Ability   string   `json:"baseAbility"`
+}
+
+// Name returns a name of a Pokémon, without a side.
+func (p SidePokemon) Name() string {
+	_, name := splitPokemonID(p.Ident)
+	return name
+}
+
+// Species returns species of a Pokémon.
+func (p SidePokemon) Species() string {
+	return strings.SplitN(p.Details, ",", 2)[0]
+}
+
+// Fainted checks whether a Pokémon has fainted.
+func (p SidePokemon) Fainted() bool {
+	return strings.HasSuffix(p.Condition, " fnt")
+}
+
+// RequestFlag is a flag which Showdown sends either as a boolean, or as
+// a string describing the reason for it being set.
+type RequestFlag bool
+
+// UnmarshalJSON parses a flag.
+func (f *RequestFlag) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case bool:
+		*f = RequestFlag(value)
+	case string:
+		*f = value != ""
+	default:
+		*f = false
+	}
+	return nil
+}
+
+func (b *Battle) setRequest(argument string) {
+	if argument == "" {
+		return
+	}
+	var request BattleRequest
+	if err := json.Unmarshal([]byte(argument), &request); err != nil {
+		return
+	}
+	b.request = &request
+}
@@ -26,6 +26,10 @@ type Room struct {
 	ID            RoomID
 	BotConnection *BotConnection
 	UserList      map[UserID]User
+	// State of a battle, nil for chat rooms
+	Battle *Battle
+	// The last tournament in a room, nil when there wasn't any
+	Tournament *Tournament
 }
 
 // User represents an user name with a rank
@@ -0,0 +1,287 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tournament represents a state of a tournament in a room, as sent by
+// |tournament| messages.
+type Tournament struct {
+	Format    string `json:"format"`
+	Generator string `json:"generator"`
+	PlayerCap int    `json:"playerCap"`
+	Started   bool   `json:"isStarted"`
+	// Whether the user joined the tournament
+	Joined bool `json:"isJoined"`
+	// Users that the user can challenge, and that can challenge the user
+	Challenges   []UserID `json:"challenges"`
+	ChallengeBys []UserID `json:"challengeBys"`
+	// Users that challenged the user, and that were challenged by the
+	// user
+	Challenged  UserID   `json:"challenged"`
+	Challenging UserID   `json:"challenging"`
+	Bracket     *Bracket `json:"bracketData"`
+	// Players that joined, before the tournament started
+	Players []string `json:"-"`
+	Ended   bool     `json:"-"`
+	// Winners of a finished tournament
+	Winners []string `json:"-"`
+}
+
+// Opponents returns users that the user can battle next, either by
+// challenging them, or by being challenged.
+func (t *Tournament) Opponents() []UserID {
+	return append(append([]UserID{}, t.Challenges...), t.ChallengeBys...)
+}
+
+// Tournament returns a copy of a tournament in a room, or nil when
+// there isn't one, which can be read by other goroutines than the one
+// updating tournaments. Brackets are replaced rather than changed by
+// updates, so they are shared.
+func (bc *BotConnection) Tournament(id RoomID) *Tournament {
+	bc.stateMutex.RLock()
+	defer bc.stateMutex.RUnlock()
+	room, ok := bc.rooms[id]
+	if !ok || room.Tournament == nil {
+		return nil
+	}
+	tournament := *room.Tournament
+	tournament.Challenges = append([]UserID(nil), tournament.Challenges...)
+	tournament.ChallengeBys = append([]UserID(nil), tournament.ChallengeBys...)
+	tournament.Players = append([]string(nil), tournament.Players...)
+	tournament.Winners = append([]string(nil), tournament.Winners...)
+	return &tournament
+}
+
+// clearField resets a field that can be null, given by its JSON name.
+func (t *Tournament) clearField(name string) {
+	switch name {
+	case "challenges":
+		t.Challenges = nil
+	case "challengeBys":
+		t.ChallengeBys = nil
+	case "challenged":
+		t.Challenged = ""
+	case "challenging":
+		t.Challenging = ""
+	case "bracketData":
+		t.Bracket = nil
+	}
+}
+
+// Bracket is a tournament bracket, either a tree for elimination
+// tournaments, or a table for round robin.
+type Bracket struct {
+	Type     string       `json:"type"`
+	RootNode *BracketNode `json:"rootNode"`
+	Headers  struct {
+		Cols []string `json:"cols"`
+		Rows []string `json:"rows"`
+	} `json:"tableHeaders"`
+	// Cells by row and column, nil for battles of a player with
+	// themselves
+	Contents [][]*BracketCell `json:"tableContents"`
+	Scores   []float64        `json:"scores"`
+}
+
+// BracketNode is a battle in an elimination bracket. Leaf nodes are
+// players.
+type BracketNode struct {
+	// The player, or a winner of the battle
+	Team  string `json:"team"`
+	State string `json:"state"`
+	// Result from the point of view of the first child, win, loss or draw
+	Result   string         `json:"result"`
+	Score    []int          `json:"score"`
+	Children []*BracketNode `json:"children"`
+}
+
+// BracketCell is a battle in a round robin table.
+type BracketCell struct {
+	State string `json:"state"`
+	// Result from the point of view of a row, win, loss or draw
+	Result string `json:"result"`
+	Score  []int  `json:"score"`
+}
+
+// Lines renders a bracket as text. Battles of an elimination bracket
+// are shown with the final first, and earlier rounds indented.
+func (b *Bracket) Lines() []string {
+	if b == nil {
+		return nil
+	}
+	if b.Type == "table" {
+		return b.tableLines()
+	}
+	var lines []string
+	b.RootNode.appendLines(&lines, 0)
+	return lines
+}
+
+func (n *BracketNode) appendLines(lines *[]string, depth int) {
+	if n == nil || len(n.Children) != 2 {
+		return
+	}
+	first, second := n.Children[0].name(), n.Children[1].name()
+	text := first + " vs. " + second
+	switch n.State {
+	case "finished":
+		text = DescribeBattleResult(first, second, n.Result, n.Score)
+	case "inprogress":
+		text += " (in progress)"
+	}
+	*lines = append(*lines, strings.Repeat("  ", depth)+text)
+	for _, child := range n.Children {
+		child.appendLines(lines, depth+1)
+	}
+}
+
+func (n *BracketNode) name() string {
+	if n == nil || n.Team == "" {
+		return "?"
+	}
+	return n.Team
+}
+
+func (b *Bracket) tableLines() []string {
+	var lines []string
+	for i, row := range b.Headers.Rows {
+		var results []string
+		var cells []*BracketCell
+		if i < len(b.Contents) {
+			cells = b.Contents[i]
+		}
+		for j, cell := range cells {
+			if cell == nil || cell.State != "finished" || j >= len(b.Headers.Cols) {
+				continue
+			}
+			results = append(results, DescribeBattleResult(row, b.Headers.Cols[j], cell.Result, cell.Score))
+		}
+		line := row
+		if i < len(b.Scores) {
+			line += " (" + strconv.FormatFloat(b.Scores[i], 'f', -1, 64) + ")"
+		}
+		if len(results) != 0 {
+			line += ": " + strings.Join(results, ", ")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// DescribeBattleResult describes a result of a tournament battle, which
+// is win, loss or draw from the point of view of the first player.
+func DescribeBattleResult(first, second, result string, score []int) string {
+	scoreText := ""
+	if len(score) == 2 {
+		scoreText = fmt.Sprintf(" (%d-%d)", score[0], score[1])
+		if result == "loss" {
+			scoreText = fmt.Sprintf(" (%d-%d)", score[1], score[0])
+		}
+	}
+	switch result {
+	case "win":
+		return first + " beat " + second + scoreText
+	case "loss":
+		return second + " beat " + first + scoreText
+	}
+	return first + " tied with " + second + scoreText
+}
+
+// updateTournament handles |tournament| messages, which have a type
+// followed by arguments.
+func updateTournament(rawMessage string, room *Room) {
+	parts := strings.SplitN(rawMessage, "|", 2)
+	argument := ""
+	if len(parts) > 1 {
+		argument = parts[1]
+	}
+	args := strings.Split(argument, "|")
+	tournament := room.Tournament
+	switch parts[0] {
+	case "create":
+		tournament = &Tournament{Format: args[0]}
+		if len(args) >= 2 {
+			tournament.Generator = args[1]
+		}
+		if len(args) >= 3 {
+			tournament.PlayerCap, _ = strconv.Atoi(args[2])
+		}
+		room.Tournament = tournament
+	case "update":
+		if tournament == nil {
+			tournament = &Tournament{}
+			room.Tournament = tournament
+		}
+		// Updates only contain fields that changed. Fields which are
+		// present are cleared first, as unmarshalling null doesn't
+		// change them, and brackets are replaced rather than merged.
+		var fields map[string]json.RawMessage
+		if json.Unmarshal([]byte(argument), &fields) != nil {
+			return
+		}
+		for name := range fields {
+			tournament.clearField(name)
+		}
+		json.Unmarshal([]byte(argument), tournament)
+	case "join":
+		if tournament != nil {
+			tournament.Players = append(tournament.Players, args[0])
+		}
+	case "leave", "disqualify":
+		if tournament != nil {
+			tournament.removePlayer(args[0])
+		}
+	case "start":
+		if tournament != nil {
+			tournament.Started = true
+		}
+	case "end":
+		var result struct {
+			Results [][]string `json:"results"`
+			Bracket *Bracket   `json:"bracketData"`
+		}
+		if tournament == nil || json.Unmarshal([]byte(argument), &result) != nil {
+			return
+		}
+		tournament.Ended = true
+		if len(result.Results) > 0 {
+			tournament.Winners = result.Results[0]
+		}
+		if result.Bracket != nil {
+			tournament.Bracket = result.Bracket
+		}
+	case "forceend":
+		if tournament != nil {
+			tournament.Ended = true
+		}
+	}
+}
+
+func (t *Tournament) removePlayer(name string) {
+	for i, player := range t.Players {
+		if ToID(player) == ToID(name) {
+			t.Players = append(t.Players[:i], t.Players[i+1:]...)
+			return
+		}
+	}
+}
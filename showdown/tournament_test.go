@@ -0,0 +1,87 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package showdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const treeBracket = `{"type":"tree","rootNode":{"state":"inprogress","children":[` +
+	`{"team":"Alice","state":"finished","result":"loss","score":[0,1],"children":[{"team":"Carol"},{"team":"Alice"}]},` +
+	`{"team":"Bob"}]}}`
+
+func TestTournament(t *testing.T) {
+	bc := &BotConnection{commandCallback: func(command, argument string, room *Room) {}, rooms: map[RoomID]*Room{}}
+	bc.rooms["tours"] = &Room{ID: "tours", BotConnection: bc}
+	bc.parseMessage(">tours\n|tournament|create|gen9ou|Single Elimination|8\n" +
+		"|tournament|join|Alice\n|tournament|join|Bob\n|tournament|leave|alice\n" +
+		`|tournament|update|{"isJoined":true,"challenges":["bob"]}` + "\n" +
+		"|tournament|start|3\n" +
+		`|tournament|update|{"bracketData":` + treeBracket + `}`)
+	tournament := bc.Room("tours").Tournament
+	assert.Equal(t, tournament.Format, "gen9ou", "format")
+	assert.Equal(t, tournament.Generator, "Single Elimination", "generator")
+	assert.Equal(t, tournament.PlayerCap, 8, "player cap")
+	assert.Equal(t, tournament.Players, []string{"Bob"}, "players")
+	assert.True(t, tournament.Started, "the tournament should be started")
+	assert.True(t, tournament.Joined, "the user should be in the tournament")
+	assert.Equal(t, tournament.Opponents(), []UserID{"bob"}, "opponents")
+	assert.Equal(t, tournament.Bracket.Lines(), []string{
+		"Alice vs. Bob (in progress)",
+		"  Alice beat Carol (1-0)",
+	}, "bracket")
+
+	bc.parseMessage(">tours\n" + `|tournament|update|{"challenged":"bob","challenges":[]}`)
+	assert.Equal(t, tournament.Challenged, UserID("bob"), "challenged by")
+	bc.parseMessage(">tours\n" + `|tournament|update|{"challenged":null,"challenges":null}`)
+	assert.Equal(t, tournament.Challenged, UserID(""), "null should clear a challenge")
+	assert.Empty(t, tournament.Challenges, "null should clear users that can be challenged")
+	assert.True(t, tournament.Joined, "fields that weren't sent should stay")
+
+	bc.parseMessage(">tours\n" + `|tournament|end|{"results":[["Alice"]],"bracketData":{"type":"tree"}}`)
+	assert.True(t, tournament.Ended, "the tournament should be ended")
+	assert.Equal(t, tournament.Winners, []string{"Alice"}, "winners")
+
+	copied := bc.Tournament("tours")
+	bc.parseMessage(">tours\n|tournament|join|Carol")
+	assert.Equal(t, copied.Players, []string{"Bob"}, "a copy shouldn't change")
+	assert.Nil(t, bc.Tournament("lobby"), "rooms without tournaments")
+}
+
+func TestRoundRobinBracket(t *testing.T) {
+	bracket := &Bracket{Type: "table", Scores: []float64{1, 0.5}}
+	bracket.Headers.Rows = []string{"Alice", "Bob"}
+	bracket.Headers.Cols = []string{"Alice", "Bob"}
+	bracket.Contents = [][]*BracketCell{
+		{nil, {State: "finished", Result: "win", Score: []int{1, 0}}},
+		{{State: "finished", Result: "loss", Score: []int{0, 1}}, nil},
+	}
+	assert.Equal(t, bracket.Lines(), []string{
+		"Alice (1): Alice beat Bob (1-0)",
+		"Bob (0.5): Alice beat Bob (1-0)",
+	}, "round robin bracket")
+}
+
+func TestShortRoundRobinBracket(t *testing.T) {
+	bracket := &Bracket{Type: "table"}
+	bracket.Headers.Rows = []string{"Alice", "Bob"}
+	bracket.Headers.Cols = []string{"Alice", "Bob"}
+	bracket.Contents = [][]*BracketCell{{nil, nil}}
+	assert.Equal(t, bracket.Lines(), []string{"Alice", "Bob"}, "rows without contents")
+}
@@ -53,6 +53,89 @@ func ToID(name string) UserID {
 	return UserID(buffer.String())
 }
 
+// ToRoomID converts a room name to its ID.
+//
+// Unlike user IDs, room IDs can contain dashes, as used by battle rooms
+// and group chats.
+func ToRoomID(name string) RoomID {
+	var buffer bytes.Buffer
+	for _, character := range name {
+		character = unicode.ToLower(character)
+		if 'a' <= character && character <= 'z' || '0' <= character && character <= '9' || character == '-' {
+			buffer.WriteRune(character)
+		}
+	}
+	return RoomID(buffer.String())
+}
+
+// MaxMessageLength is the maximum length of a chat message that
+// Showdown accepts from most users.
+const MaxMessageLength = 300
+
+// EscapeMessage escapes a chat message, so that it is displayed as
+// it is instead of being used as a command.
+func EscapeMessage(message string) string {
+	if strings.HasPrefix(message, "/") {
+		return "/" + message
+	} else if strings.HasPrefix(message, "!") || strings.HasPrefix(message, ">> ") || strings.HasPrefix(message, ">>> ") {
+		return " " + message
+	}
+	return message
+}
+
+// MessageLength computes the length of a message as seen by Showdown,
+// which counts UTF-16 code units.
+func MessageLength(message string) int {
+	length := 0
+	for _, character := range message {
+		length += utf16Length(character)
+	}
+	return length
+}
+
+func utf16Length(character rune) int {
+	if character >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// SplitMessage splits a message into parts no longer than limit, as
+// measured by MessageLength. Messages are split on spaces when possible.
+func SplitMessage(message string, limit int) []string {
+	var parts []string
+	for MessageLength(message) > limit {
+		end := 0
+		lastSpace := -1
+		length := 0
+		for i, character := range message {
+			if unicode.IsSpace(character) {
+				lastSpace = i
+			}
+			size := utf16Length(character)
+			if length+size > limit {
+				end = i
+				break
+			}
+			length += size
+		}
+		if lastSpace > 0 {
+			end = lastSpace
+		} else if end == 0 {
+			// The limit is too small for even a single character
+			_, end = utf8.DecodeRuneInString(message)
+		}
+		if part := strings.TrimRightFunc(message[:end], unicode.IsSpace); part != "" {
+			parts = append(parts, part)
+		}
+		message = strings.TrimLeftFunc(message[end:], unicode.IsSpace)
+	}
+	if message != "" {
+		parts = append(parts, message)
+	}
+	return parts
+}
+
 type serverDoesNotExistError struct{}
 
 func (serverDoesNotExistError) Error() string {
@@ -17,6 +17,7 @@
 package showdown
 
 import (
+	"image/color"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,3 +29,73 @@ func TestToID(t *testing.T) {
 	expect := UserID("thissacompletelyregulart3st1ngnick")
 	assert.Equal(t, result, expect, "ToID(%#q)", message)
 }
+
+func TestToRoomID(t *testing.T) {
+	message := "Battle-Gen7 OU-123!"
+	result := ToRoomID(message)
+	expect := RoomID("battle-gen7ou-123")
+	assert.Equal(t, result, expect, "ToRoomID(%#q)", message)
+}
+
+var splitMessageTests = []struct {
+	in    string
+	limit int
+	out   []string
+}{
+	{"short", 10, []string{"short"}},
+	{"split on a space", 10, []string{"split on a", "space"}},
+	{"many    spaces   here", 8, []string{"many", "spaces", "here"}},
+	{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+	{"zażółć gęślą", 6, []string{"zażółć", "gęślą"}},
+	{"😀😀😀", 4, []string{"😀😀", "😀"}},
+	{"", 10, nil},
+}
+
+func TestSplitMessage(t *testing.T) {
+	for _, test := range splitMessageTests {
+		assert.Equal(t, SplitMessage(test.in, test.limit), test.out, "SplitMessage(%#q, %d)", test.in, test.limit)
+	}
+}
+
+func TestEscapeMessage(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"hi", "hi"},
+		{"/me", "//me"},
+		{"!dt", " !dt"},
+		{">> 1 + 1", " >> 1 + 1"},
+		{">>text", ">>text"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, EscapeMessage(test.in), test.out, "EscapeMessage(%#q)", test.in)
+	}
+}
+
+func TestNameColor(t *testing.T) {
+	assert.Equal(t, NameColor("Zarel"), color.RGBA{48, 124, 107, 255}, "NameColor(%#q)", "Zarel")
+	SetCustomColors(map[string]string{"Someone": "Zarel"})
+	defer SetCustomColors(nil)
+	assert.Equal(t, NameColor("someone"), NameColor("zarel"), "custom colors should be used")
+}
+
+func TestJoinedRoom(t *testing.T) {
+	bc := &BotConnection{commandCallback: func(command, argument string, room *Room) {}, rooms: map[RoomID]*Room{}}
+	bc.parseMessage("|init|chat")
+	bc.parseMessage(">tech-support\n|init|chat")
+	tests := []struct {
+		name string
+		id   RoomID
+	}{
+		{"#Lobby", ""},
+		{"lobby", ""},
+		{"#Tech-Support", "tech-support"},
+		{"TECH-SUPPORT", "tech-support"},
+	}
+	for _, test := range tests {
+		room, ok := bc.JoinedRoom(test.name)
+		if assert.True(t, ok, "JoinedRoom(%#q) should find a room", test.name) {
+			assert.Equal(t, room.ID, test.id, "JoinedRoom(%#q) room ID", test.name)
+		}
+	}
+	_, ok := bc.JoinedRoom("#Help")
+	assert.False(t, ok, "rooms which weren't joined shouldn't be found")
+}
@@ -16,9 +16,31 @@
 
 package main
 
-import "log"
+import (
+	"flag"
+	"log"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
 
 func main() {
+	customColors := flag.String("colors", "", "JSON file with custom name colors")
+	view := flag.String("battle-view", "full", "how battles are shown: full, compact or results")
+	flag.BoolVar(&saveResultReplays, "results-save-replay", false, "upload replays of battles shown in results view once they end")
+	flag.BoolVar(&colorNicks, "color-nicks", false, "color names in notices generated by the proxy")
+	flag.StringVar(&teamsDirectory, "teams", teamsDirectory, "directory in which teams are stored")
+	flag.StringVar(&replaysDirectory, "replays", replaysDirectory, "directory to which battle logs are exported")
+	flag.StringVar(&showdown.ReplayUploadURL, "replay-upload-url", showdown.ReplayUploadURL, "endpoint to which replays are uploaded")
+	flag.Parse()
+	var err error
+	if defaultBattleView, err = parseBattleView(*view); err != nil {
+		log.Fatal(err)
+	}
+	if *customColors != "" {
+		if err := showdown.LoadCustomColors(*customColors); err != nil {
+			log.Fatal(err)
+		}
+	}
 	log.Println("showdown2irc was started")
 	listen()
 }
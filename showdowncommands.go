@@ -19,8 +19,12 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/xfix/showdown2irc/chatformat"
+	"github.com/xfix/showdown2irc/html2irc"
 	"github.com/xfix/showdown2irc/irc"
 	"github.com/xfix/showdown2irc/showdown"
 )
@@ -28,7 +32,7 @@ import (
 var rankMap = map[rune]byte{'~': 'q', '#': 'r', '&': 'a', '@': 'o', '%': 'h', '*': 'B', '+': 'v'}
 
 func meCallback(c *connection, argument string, author string, room *showdown.Room) {
-	c.send(author, "PRIVMSG", escapeRoom(room.ID), fmt.Sprintf("\x01ACTION %s\x01", argument))
+	c.sendChatMessage(author, room, fmt.Sprintf("\x01ACTION %s\x01", chatformat.ToIRC(argument)))
 }
 
 var chatMessageCallbacks = map[string]func(*connection, string, string, *showdown.Room){
@@ -63,50 +67,161 @@ var showdownCommands = map[string]func(*connection, string, *showdown.Room){
 		}
 		c.sendNumeric(irc.RplEndOfNames, id)
 	},
-	"c:": func(c *connection, rawMessage string, room *showdown.Room) {
-		parts := strings.SplitN(rawMessage, "|", 3)
-		escapedAuthor := escapeUserWithHost(showdown.SplitUser(parts[1]).Name)
-		contents := parts[2]
-		if strings.HasPrefix(contents, "//") {
-			// Get rid of one /
-			contents = contents[1:]
-		} else if strings.HasPrefix(contents, "/") {
-			parts := strings.SplitN(contents[1:], " ", 2)
-			command := parts[0]
-			argument := ""
-			if len(parts) == 2 {
-				argument = parts[1]
-			}
-			if callback, ok := chatMessageCallbacks[command]; ok {
-				callback(c, argument, escapedAuthor, room)
-				return
-			}
+	"init": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.takePendingCommand(room.ID, "JOIN")
+	},
+	"noinit": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.takePendingCommand(room.ID, "JOIN")
+		parts := strings.SplitN(rawMessage, "|", 2)
+		reason := ""
+		if len(parts) == 2 {
+			reason = parts[1]
 		}
-		if escapedAuthor != c.nickname {
-			c.send(escapedAuthor, "PRIVMSG", escapeRoom(room.ID), contents)
+		channel := escapeRoom(room.ID)
+		switch parts[0] {
+		case "nonexistent":
+			c.sendNumericReason(irc.ErrNoSuchChannel, channel, reason)
+		case "joinfailed":
+			c.sendNumericReason(joinFailedNumeric(reason), channel, reason)
+		case "namerequired":
+			c.sendNumericReason(irc.ErrNeedReggedNick, channel, reason)
+		case "rename":
+			// The room was renamed, the argument contains new ID
+			// and title of the room.
+			newID := strings.SplitN(reason, "|", 2)[0]
+			c.addPendingCommand(showdown.RoomID(newID), "JOIN")
+			c.showdown.SendGlobalCommand("join", newID)
+		default:
+			c.sendGlobal("NOTICE", channel, reason)
 		}
 	},
-	"L": func(c *connection, rawMessage string, room *showdown.Room) {
-		name := showdown.SplitUser(rawMessage).Name
-		c.send(escapeUserWithHost(name), "PART", escapeRoom(room.ID), "")
+	"request": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.showBattleRequest(room)
+	},
+	"updatechallenges": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.showChallenges(room.BotConnection.Challenges())
 	},
-	"J": func(c *connection, rawMessage string, room *showdown.Room) {
-		user := showdown.SplitUser(rawMessage)
-		c.send(escapeUserWithHost(user.Name), "JOIN", escapeRoom(room.ID))
-		if ircRank, ok := rankMap[user.Rank]; ok {
-			c.sendGlobal("MODE", escapeRoom(room.ID), fmt.Sprintf("+%c", ircRank), escapeUser(user.Name))
+	"updatesearch": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.joinNewBattles(room.BotConnection.Search())
+	},
+	"tournament": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.showTournamentEvent(rawMessage, room)
+	},
+	"queryresponse": func(c *connection, rawMessage string, room *showdown.Room) {
+		parts := strings.SplitN(rawMessage, "|", 2)
+		if len(parts) == 2 && parts[0] == "savereplay" {
+			c.uploadReplay(parts[1])
 		}
 	},
-	"pm": func(c *connection, rawMessage string, room *showdown.Room) {
+	"popup": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.sendGlobal("NOTICE", c.nickname, popupText(rawMessage))
+	},
+	"deinit": func(c *connection, rawMessage string, room *showdown.Room) {
+		delete(c.uhtml, roomKey(room.ID))
+		delete(c.chatMessages, roomKey(room.ID))
+		c.forgetBattleView(room.ID)
+		delete(c.turnSummaries, roomKey(room.ID))
+		delete(c.tournamentNotices, roomKey(room.ID))
+		c.clearPendingCommands(room.ID)
+		channel := escapeRoom(room.ID)
+		if reason := c.lastNotice; isRemovalNotice(reason) {
+			kicker := serverName
+			if match := removedByRegexp.FindStringSubmatch(reason); match != nil {
+				kicker = escapeUserWithHost(match[1])
+			}
+			c.send(kicker, "KICK", channel, c.nickname, reason)
+		} else {
+			c.send(escapeUserWithHost(c.loginData.Nickname), "PART", channel, "")
+		}
+	},
+	"error": func(c *connection, rawMessage string, room *showdown.Room) {
+		channel := escapeRoom(room.ID)
+		command, global := c.popPendingCommand(room.ID)
+		if global {
+			c.sendGlobal("NOTICE", c.nickname, rawMessage)
+			return
+		}
+		switch command {
+		case "PRIVMSG":
+			c.sendNumericReason(irc.ErrCannotSendToChan, channel, rawMessage)
+		case "JOIN":
+			c.sendNumericReason(joinFailedNumeric(rawMessage), channel, rawMessage)
+		default:
+			if strings.HasPrefix(rawMessage, "Access denied") {
+				c.sendNumericReason(irc.ErrChanOpPrivIsNeeded, channel, rawMessage)
+			} else {
+				c.sendGlobal("NOTICE", channel, rawMessage)
+			}
+		}
+	},
+	"hidelines": func(c *connection, rawMessage string, room *showdown.Room) {
+		// |hidelines|TYPE|USER|LINES, where TYPE is either hide or delete
 		parts := strings.SplitN(rawMessage, "|", 3)
-		contents := parts[2]
-		escapedAuthor := escapeUserWithHost(showdown.SplitUser(parts[0]).Name)
-		if escapedAuthor != c.nickname {
-			c.send(escapedAuthor, "PRIVMSG", escapedAuthor, contents)
+		if len(parts) < 2 {
+			return
 		}
+		lines := 0
+		if len(parts) == 3 {
+			lines, _ = strconv.Atoi(parts[2])
+		}
+		c.hideLines(room, showdown.ToID(parts[1]), lines, parts[0] == "delete")
+	},
+	"unlink": func(c *connection, rawMessage string, room *showdown.Room) {
+		// |unlink|hide|USER|LINES hides messages, while |unlink|USER
+		// only removes links from them, which cannot be done on IRC.
+		parts := strings.SplitN(rawMessage, "|", 3)
+		if len(parts) < 2 || parts[0] != "hide" {
+			return
+		}
+		lines := 0
+		if len(parts) == 3 {
+			lines, _ = strconv.Atoi(parts[2])
+		}
+		c.hideLines(room, showdown.ToID(parts[1]), lines, false)
 	},
 	"raw":  htmlCommand,
 	"html": htmlCommand,
+	"uhtml": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.showUHTML(room, rawMessage, false)
+	},
+	"uhtmlchange": func(c *connection, rawMessage string, room *showdown.Room) {
+		c.showUHTML(room, rawMessage, true)
+	},
+}
+
+// popupText converts a Showdown popup into a single line of text.
+func popupText(popup string) string {
+	popup = strings.TrimPrefix(popup, "|modal|")
+	if strings.HasPrefix(popup, "|html|") {
+		return strings.Join(html2irc.HTMLToIRC(popup[len("|html|"):]), " ")
+	}
+	// Popups use || to separate lines
+	return strings.Replace(popup, "||", " ", -1)
+}
+
+var removedByRegexp = regexp.MustCompile(` by ([^.]+)\.`)
+
+// isRemovalNotice checks whether a notice explains that the user was
+// forcibly removed from a room by a moderator.
+func isRemovalNotice(notice string) bool {
+	notice = strings.ToLower(notice)
+	for _, word := range []string{"kicked", "banned", "removed"} {
+		if strings.Contains(notice, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinFailedNumeric figures out a numeric for a failure to join a room.
+//
+// Showdown doesn't distinguish between being banned and a room being
+// moderated (modjoin), so this looks at the error message.
+func joinFailedNumeric(reason string) irc.Numeric {
+	if strings.Contains(strings.ToLower(reason), "banned") {
+		return irc.ErrBannedFromChan
+	}
+	return irc.ErrInviteOnlyChan
 }
 
 func htmlCommand(c *connection, rawMessage string, room *showdown.Room) {
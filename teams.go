@@ -0,0 +1,180 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xfix/showdown2irc/showdown"
+	"github.com/xfix/showdown2irc/teams"
+)
+
+// Directory in which teams are stored, with a subdirectory for every
+// Showdown user
+var teamsDirectory = filepath.Join(os.Getenv("HOME"), ".showdown2irc", "teams")
+
+// Teams are pasted as private messages to this pseudo-user, which also
+// sends notices about teams, so that they appear in a single query
+// window.
+const (
+	teamBuilderNick   = "TeamBuilder"
+	teamBuilderPrefix = teamBuilderNick + "!teams@" + serverName
+)
+
+// teamImport is a team being pasted.
+type teamImport struct {
+	format, name string
+	lines        []string
+}
+
+func (c *connection) teamStore() teams.Store {
+	return teams.NewStore(filepath.Join(teamsDirectory, string(showdown.ToID(c.loginData.Nickname))))
+}
+
+func (c *connection) sendTeamNotice(format string, arguments ...interface{}) {
+	c.send(teamBuilderPrefix, "NOTICE", c.nickname, fmt.Sprintf(format, arguments...))
+}
+
+// addTeamLine handles a message sent to TeamBuilder pseudo-user.
+func (c *connection) addTeamLine(line string) {
+	if c.teamImport == nil {
+		c.sendTeamNotice("Use /teams import format name to import a team first")
+		return
+	}
+	if strings.EqualFold(strings.TrimSpace(line), "END") {
+		c.finishTeamImport()
+		return
+	}
+	c.teamImport.lines = append(c.teamImport.lines, line)
+}
+
+func (c *connection) finishTeamImport() {
+	current := c.teamImport
+	if current == nil {
+		c.sendTeamNotice("No team is being imported")
+		return
+	}
+	c.teamImport = nil
+	team, err := teams.ParseExport(strings.Join(current.lines, "\n"))
+	if err != nil {
+		c.sendTeamNotice("Couldn't import %s: %s", current.name, err)
+		return
+	}
+	if err := c.teamStore().Save(current.format, current.name, team); err != nil {
+		c.sendTeamNotice("Couldn't save %s: %s", current.name, err)
+		return
+	}
+	c.sendTeamNotice("Imported %s with %d Pokémon for %s", current.name, len(team), current.format)
+}
+
+// teamSubcommands handle TEAMS command, and receive parameters after
+// a subcommand name.
+var teamSubcommands = map[string]func(c *connection, params []string){
+	"LIST": func(c *connection, params []string) {
+		store := c.teamStore()
+		formats := params
+		if len(formats) == 0 {
+			var err error
+			if formats, err = store.Formats(); err != nil {
+				c.sendTeamNotice("Couldn't list teams: %s", err)
+				return
+			}
+		}
+		found := false
+		for _, format := range formats {
+			names, err := store.List(format)
+			if err != nil {
+				c.sendTeamNotice("Couldn't list teams: %s", err)
+				return
+			}
+			if len(names) != 0 {
+				c.sendTeamNotice("%s: %s", showdown.ToID(format), strings.Join(names, ", "))
+				found = true
+			}
+		}
+		if !found {
+			c.sendTeamNotice("No teams found")
+		}
+	},
+	"IMPORT": teamCommand(func(c *connection, format, name string) {
+		c.teamImport = &teamImport{format: format, name: name}
+		c.sendTeamNotice("Paste the team in export format here, then send END")
+	}),
+	"END": func(c *connection, params []string) {
+		c.finishTeamImport()
+	},
+	"SHOW": teamCommand(func(c *connection, format, name string) {
+		team, err := c.teamStore().Load(format, name)
+		if err != nil {
+			c.sendTeamNotice("Couldn't load %s: %s", name, err)
+			return
+		}
+		for _, line := range strings.Split(teams.Export(team), "\n") {
+			if line != "" {
+				c.sendTeamNotice("%s", line)
+			}
+		}
+	}),
+	"USE": teamCommand(func(c *connection, format, name string) {
+		team, err := c.teamStore().Load(format, name)
+		if err != nil {
+			c.sendTeamNotice("Couldn't load %s: %s", name, err)
+			return
+		}
+		c.showdown.SendGlobalCommand("utm", teams.Pack(team))
+		c.sendTeamNotice("Using %s, you can now challenge or search for a %s battle", name, format)
+	}),
+	"DELETE": teamCommand(func(c *connection, format, name string) {
+		if err := c.teamStore().Delete(format, name); err != nil {
+			c.sendTeamNotice("Couldn't delete %s: %s", name, err)
+			return
+		}
+		c.sendTeamNotice("Deleted %s", name)
+	}),
+}
+
+// teamCommand creates a subcommand of TEAMS that takes a format and
+// a team name, which can contain spaces.
+func teamCommand(callback func(c *connection, format, name string)) func(*connection, []string) {
+	return func(c *connection, params []string) {
+		if len(params) < 2 {
+			c.needMoreParams("TEAMS")
+			return
+		}
+		callback(c, params[0], strings.Join(params[1:], " "))
+	}
+}
+
+func teamsCommand(c *connection, command []string) {
+	if len(command) < 1 {
+		c.needMoreParams("TEAMS")
+		return
+	}
+	subcommand, ok := teamSubcommands[strings.ToUpper(command[0])]
+	if !ok {
+		c.sendTeamNotice("Unknown subcommand %s, use LIST, IMPORT, END, SHOW, USE or DELETE", command[0])
+		return
+	}
+	var params []string
+	for _, param := range command[1:] {
+		params = append(params, strings.Fields(unescapeUser(param))...)
+	}
+	subcommand(c, params)
+}
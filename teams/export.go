@@ -0,0 +1,204 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package teams
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrEmptyTeam is returned when parsing a team without any Pokémon.
+var ErrEmptyTeam = errors.New("the team doesn't contain any Pokémon")
+
+// ParseExport parses a team in export format, as used by teambuilder.
+//
+// Sets are usually separated with empty lines, but as those can get
+// lost, for instance when pasted into IRC, a line that doesn't describe
+// a set starts a new one too.
+func ParseExport(text string) (Team, error) {
+	var team Team
+	var current *Set
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "==="):
+			// Team headers look like === [gen7ou] Name ===
+			current = nil
+		case current != nil && current.parseLine(line):
+		default:
+			team = append(team, parseFirstLine(line))
+			current = &team[len(team)-1]
+		}
+	}
+	if len(team) == 0 {
+		return nil, ErrEmptyTeam
+	}
+	return team, nil
+}
+
+// parseFirstLine parses a line like "Nickname (Species) (M) @ Item".
+func parseFirstLine(line string) Set {
+	set := NewSet("")
+	if at := strings.LastIndex(line, " @ "); at >= 0 {
+		set.Item = strings.TrimSpace(line[at+len(" @ "):])
+		line = strings.TrimSpace(line[:at])
+	}
+	if strings.HasSuffix(line, " (M)") || strings.HasSuffix(line, " (F)") {
+		set.Gender = line[len(line)-2 : len(line)-1]
+		line = line[:len(line)-len(" (M)")]
+	}
+	if open := strings.LastIndex(line, " ("); open >= 0 && strings.HasSuffix(line, ")") {
+		set.Name = line[:open]
+		set.Species = line[open+len(" (") : len(line)-1]
+	} else {
+		set.Species = line
+	}
+	return set
+}
+
+// parseLine parses a line describing a set, like "Ability: Static". It
+// returns false when a line doesn't describe a set.
+func (s *Set) parseLine(line string) bool {
+	if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "~ ") {
+		s.Moves = append(s.Moves, strings.TrimSpace(line[2:]))
+		return true
+	}
+	if strings.HasSuffix(line, " Nature") {
+		s.Nature = strings.TrimSuffix(line, " Nature")
+		return true
+	}
+	parts := strings.SplitN(line, ": ", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	value := strings.TrimSpace(parts[1])
+	number, _ := strconv.Atoi(value)
+	switch parts[0] {
+	case "Ability":
+		s.Ability = value
+	case "Level":
+		s.Level = number
+	case "Shiny":
+		s.Shiny = value == "Yes"
+	case "Happiness":
+		s.Happiness = number
+	case "Pokeball":
+		s.Pokeball = value
+	case "Hidden Power":
+		s.HiddenPowerType = value
+	case "Gigantamax":
+		s.Gigantamax = value == "Yes"
+	case "Dynamax Level":
+		s.DynamaxLevel = number
+	case "Tera Type":
+		s.TeraType = value
+	case "EVs":
+		s.EVs = parseStats(value, s.EVs)
+	case "IVs":
+		s.IVs = parseStats(value, s.IVs)
+	default:
+		return false
+	}
+	return true
+}
+
+// parseStats parses stats like "252 SpA / 4 SpD", changing given stats.
+func parseStats(text string, stats Stats) Stats {
+	for _, stat := range strings.Split(text, "/") {
+		fields := strings.Fields(stat)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		for i, name := range statNames {
+			if strings.EqualFold(name, fields[1]) {
+				stats[i] = value
+			}
+		}
+	}
+	return stats
+}
+
+// Export writes a team in export format.
+func Export(team Team) string {
+	var buffer bytes.Buffer
+	for i, set := range team {
+		if i != 0 {
+			buffer.WriteByte('\n')
+		}
+		set.export(&buffer)
+	}
+	return buffer.String()
+}
+
+func (s Set) export(buffer *bytes.Buffer) {
+	if s.Name != "" && s.Name != s.Species {
+		fmt.Fprintf(buffer, "%s (%s)", s.Name, s.Species)
+	} else {
+		buffer.WriteString(s.Species)
+	}
+	if s.Gender != "" {
+		fmt.Fprintf(buffer, " (%s)", s.Gender)
+	}
+	if s.Item != "" {
+		fmt.Fprintf(buffer, " @ %s", s.Item)
+	}
+	buffer.WriteByte('\n')
+
+	writeLine := func(condition bool, format string, arguments ...interface{}) {
+		if condition {
+			fmt.Fprintf(buffer, format+"\n", arguments...)
+		}
+	}
+	writeLine(s.Ability != "", "Ability: %s", s.Ability)
+	writeLine(s.Level != 0 && s.Level != defaultLevel, "Level: %d", s.Level)
+	writeLine(s.Shiny, "Shiny: Yes")
+	writeLine(s.Happiness != 0 && s.Happiness != defaultHappiness, "Happiness: %d", s.Happiness)
+	writeLine(s.Pokeball != "", "Pokeball: %s", s.Pokeball)
+	writeLine(s.HiddenPowerType != "", "Hidden Power: %s", s.HiddenPowerType)
+	writeLine(s.DynamaxLevel != 0 && s.DynamaxLevel != defaultDynamaxLevel, "Dynamax Level: %d", s.DynamaxLevel)
+	writeLine(s.Gigantamax, "Gigantamax: Yes")
+	writeLine(s.TeraType != "", "Tera Type: %s", s.TeraType)
+	if evs := formatStats(s.EVs, 0); evs != "" {
+		writeLine(true, "EVs: %s", evs)
+	}
+	writeLine(s.Nature != "", "%s Nature", s.Nature)
+	if ivs := formatStats(s.IVs, defaultIV); ivs != "" {
+		writeLine(true, "IVs: %s", ivs)
+	}
+	for _, move := range s.Moves {
+		writeLine(true, "- %s", move)
+	}
+}
+
+// formatStats writes stats like "252 SpA / 4 SpD", skipping stats with
+// a default value.
+func formatStats(stats Stats, defaultValue int) string {
+	var parts []string
+	for i, value := range stats {
+		if value != defaultValue {
+			parts = append(parts, fmt.Sprintf("%d %s", value, statNames[i]))
+		}
+	}
+	return strings.Join(parts, " / ")
+}
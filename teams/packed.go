@@ -0,0 +1,183 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package teams
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// Number of fields in a packed set, excluding optional fields after
+// happiness
+const packedFields = 12
+
+// Pack writes a team in packed format, as expected by /utm command.
+// Items, abilities and moves are written as IDs, like Showdown client
+// does.
+func Pack(team Team) string {
+	sets := make([]string, len(team))
+	for i, set := range team {
+		sets[i] = set.pack()
+	}
+	return strings.Join(sets, "]")
+}
+
+func (s Set) pack() string {
+	var buffer bytes.Buffer
+	name := s.Name
+	if name == "" {
+		name = s.Species
+	}
+	species := packName(s.Species)
+	if packName(name) == species {
+		species = ""
+	}
+	moves := make([]string, len(s.Moves))
+	for i, move := range s.Moves {
+		moves[i] = toID(move)
+	}
+	shiny := ""
+	if s.Shiny {
+		shiny = "S"
+	}
+	fields := []string{
+		name,
+		species,
+		toID(s.Item),
+		toID(s.Ability),
+		strings.Join(moves, ","),
+		s.Nature,
+		packStats(s.EVs, 0),
+		s.Gender,
+		packStats(s.IVs, defaultIV),
+		shiny,
+		packNumber(s.Level, defaultLevel),
+		packNumber(s.Happiness, defaultHappiness),
+	}
+	buffer.WriteString(strings.Join(fields, "|"))
+	if s.HiddenPowerType != "" || s.Pokeball != "" || s.Gigantamax || s.DynamaxLevel != 0 && s.DynamaxLevel != defaultDynamaxLevel || s.TeraType != "" {
+		gigantamax := ""
+		if s.Gigantamax {
+			gigantamax = "G"
+		}
+		fmt.Fprintf(&buffer, ",%s,%s,%s,%s,%s",
+			s.HiddenPowerType,
+			toID(s.Pokeball),
+			gigantamax,
+			packNumber(s.DynamaxLevel, defaultDynamaxLevel),
+			s.TeraType)
+	}
+	return buffer.String()
+}
+
+// Unpack parses a team in packed format. As packed format stores IDs,
+// names of items, abilities and moves are returned as IDs.
+func Unpack(packed string) (Team, error) {
+	var team Team
+	if strings.TrimSpace(packed) == "" {
+		return nil, ErrEmptyTeam
+	}
+	for i, packedSet := range strings.Split(packed, "]") {
+		fields := strings.Split(packedSet, "|")
+		if len(fields) != packedFields {
+			return nil, fmt.Errorf("set %d has %d fields instead of %d", i+1, len(fields), packedFields)
+		}
+		set := NewSet(fields[1])
+		set.Name = fields[0]
+		if set.Species == "" {
+			set.Species = set.Name
+		}
+		set.Item = fields[2]
+		set.Ability = fields[3]
+		if fields[4] != "" {
+			set.Moves = strings.Split(fields[4], ",")
+		}
+		set.Nature = fields[5]
+		set.EVs = unpackStats(fields[6], 0)
+		set.Gender = fields[7]
+		set.IVs = unpackStats(fields[8], defaultIV)
+		set.Shiny = fields[9] == "S"
+		set.Level, _ = strconv.Atoi(fields[10])
+		extra := strings.Split(fields[11], ",")
+		set.Happiness, _ = strconv.Atoi(extra[0])
+		if len(extra) == 6 {
+			set.HiddenPowerType = extra[1]
+			set.Pokeball = extra[2]
+			set.Gigantamax = extra[3] == "G"
+			set.DynamaxLevel, _ = strconv.Atoi(extra[4])
+			set.TeraType = extra[5]
+		}
+		team = append(team, set)
+	}
+	return team, nil
+}
+
+// packName removes characters other than letters and digits from
+// a name, keeping the case.
+func packName(name string) string {
+	var buffer bytes.Buffer
+	for _, character := range name {
+		if 'a' <= character && character <= 'z' || 'A' <= character && character <= 'Z' || '0' <= character && character <= '9' {
+			buffer.WriteRune(character)
+		}
+	}
+	return buffer.String()
+}
+
+func toID(name string) string {
+	return string(showdown.ToID(name))
+}
+
+func packNumber(value, defaultValue int) string {
+	if value == 0 || value == defaultValue {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+// packStats writes comma separated stats, leaving default values empty.
+// When all stats have default values, nothing is written.
+func packStats(stats Stats, defaultValue int) string {
+	parts := make([]string, len(stats))
+	allDefault := true
+	for i, value := range stats {
+		if value != defaultValue {
+			parts[i] = strconv.Itoa(value)
+			allDefault = false
+		}
+	}
+	if allDefault {
+		return ""
+	}
+	return strings.Join(parts, ",")
+}
+
+func unpackStats(packed string, defaultValue int) Stats {
+	var stats Stats
+	parts := strings.Split(packed, ",")
+	for i := range stats {
+		stats[i] = defaultValue
+		if i < len(parts) && parts[i] != "" {
+			stats[i], _ = strconv.Atoi(parts[i])
+		}
+	}
+	return stats
+}
@@ -0,0 +1,115 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package teams
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+const teamExtension = ".txt"
+
+// Store keeps teams on disk, in export format, so that they can be
+// edited by hand. Teams are keyed by format and name, and each format
+// has its own directory.
+type Store struct {
+	directory string
+}
+
+// NewStore creates a store keeping teams in a directory, which is
+// created when a team is saved.
+func NewStore(directory string) Store {
+	return Store{directory}
+}
+
+func (s Store) formatDirectory(format string) string {
+	return filepath.Join(s.directory, string(showdown.ToID(format)))
+}
+
+func (s Store) path(format, name string) string {
+	return filepath.Join(s.formatDirectory(format), url.QueryEscape(name)+teamExtension)
+}
+
+// List returns sorted names of teams for a format.
+func (s Store) List(format string) ([]string, error) {
+	files, err := ioutil.ReadDir(s.formatDirectory(format))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), teamExtension) {
+			continue
+		}
+		name, err := url.QueryUnescape(strings.TrimSuffix(file.Name(), teamExtension))
+		if err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Formats returns sorted IDs of formats which have any teams.
+func (s Store) Formats() ([]string, error) {
+	files, err := ioutil.ReadDir(s.directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var formats []string
+	for _, file := range files {
+		if file.IsDir() {
+			formats = append(formats, file.Name())
+		}
+	}
+	sort.Strings(formats)
+	return formats, nil
+}
+
+// Load reads a team.
+func (s Store) Load(format, name string) (Team, error) {
+	contents, err := ioutil.ReadFile(s.path(format, name))
+	if err != nil {
+		return nil, err
+	}
+	return ParseExport(string(contents))
+}
+
+// Save writes a team, replacing a team with the same name.
+func (s Store) Save(format, name string, team Team) error {
+	if err := os.MkdirAll(s.formatDirectory(format), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(format, name), []byte(Export(team)), 0600)
+}
+
+// Delete removes a team.
+func (s Store) Delete(format, name string) error {
+	return os.Remove(s.path(format, name))
+}
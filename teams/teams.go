@@ -0,0 +1,68 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package teams handles Pokémon Showdown teams, in export format used by
+// the teambuilder, and in packed format used by the protocol.
+package teams
+
+// Stats are values for each stat, in order of HP, Attack, Defense,
+// Special Attack, Special Defense and Speed.
+type Stats [6]int
+
+// Names of stats, as used in export format
+var statNames = [6]string{"HP", "Atk", "Def", "SpA", "SpD", "Spe"}
+
+// Default values of IVs and other settings, which aren't written
+const (
+	defaultIV           = 31
+	defaultLevel        = 100
+	defaultHappiness    = 255
+	defaultDynamaxLevel = 10
+)
+
+// Set describes a single Pokémon in a team. Zero values of Level,
+// Happiness and DynamaxLevel mean default values.
+type Set struct {
+	Name            string
+	Species         string
+	Item            string
+	Ability         string
+	Moves           []string
+	Nature          string
+	Gender          string
+	EVs             Stats
+	IVs             Stats
+	Shiny           bool
+	Level           int
+	Happiness       int
+	Pokeball        string
+	HiddenPowerType string
+	Gigantamax      bool
+	DynamaxLevel    int
+	TeraType        string
+}
+
+// NewSet creates a set for a species, with default IVs.
+func NewSet(species string) Set {
+	set := Set{Species: species}
+	for i := range set.IVs {
+		set.IVs[i] = defaultIV
+	}
+	return set
+}
+
+// Team is a list of Pokémon.
+type Team []Set
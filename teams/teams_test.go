@@ -0,0 +1,122 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package teams
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const exportedTeam = `Sparky (Pikachu) (M) @ Light Ball
+Ability: Lightning Rod
+Level: 50
+Shiny: Yes
+Tera Type: Electric
+EVs: 4 Atk / 252 SpA / 252 Spe
+Timid Nature
+IVs: 0 Atk
+- Thunderbolt
+- Volt Switch
+
+Ferrothorn @ Leftovers
+Ability: Iron Barbs
+EVs: 252 HP / 88 Def / 168 SpD
+Relaxed Nature
+IVs: 0 Spe
+- Spikes
+- Leech Seed
+`
+
+const packedTeam = "Sparky|Pikachu|lightball|lightningrod|thunderbolt,voltswitch|Timid|,4,,252,,252|M|,0,,,,|S|50|,,,,,Electric]" +
+	"Ferrothorn||leftovers|ironbarbs|spikes,leechseed|Relaxed|252,,88,,168,||,,,,,0|||"
+
+func TestExportRoundTrip(t *testing.T) {
+	team, err := ParseExport(exportedTeam)
+	require.NoError(t, err)
+	assert.Equal(t, len(team), 2, "number of Pokémon")
+	assert.Equal(t, team[0].Name, "Sparky", "name")
+	assert.Equal(t, team[0].Species, "Pikachu", "species")
+	assert.Equal(t, team[0].Gender, "M", "gender")
+	assert.Equal(t, team[0].EVs, Stats{0, 4, 0, 252, 0, 252}, "EVs")
+	assert.Equal(t, team[0].IVs, Stats{31, 0, 31, 31, 31, 31}, "IVs")
+	assert.Equal(t, team[1].Moves, []string{"Spikes", "Leech Seed"}, "moves")
+	assert.Equal(t, Export(team), exportedTeam, "Export")
+}
+
+func TestParseExportWithoutBlankLines(t *testing.T) {
+	// IRC clients don't send empty lines, so sets may not be separated
+	team, err := ParseExport("=== [gen9ou] Test ===\nPikachu\n- Thunderbolt\nFerrothorn @ Leftovers\n- Spikes")
+	require.NoError(t, err)
+	assert.Equal(t, len(team), 2, "number of Pokémon")
+	assert.Equal(t, team[1].Item, "Leftovers", "item")
+	assert.Equal(t, team[1].Moves, []string{"Spikes"}, "moves")
+}
+
+func TestParseExportEmpty(t *testing.T) {
+	_, err := ParseExport("\n\n")
+	assert.Equal(t, err, ErrEmptyTeam, "error")
+}
+
+func TestPack(t *testing.T) {
+	team, err := ParseExport(exportedTeam)
+	require.NoError(t, err)
+	assert.Equal(t, Pack(team), packedTeam, "Pack")
+}
+
+func TestUnpack(t *testing.T) {
+	team, err := Unpack(packedTeam)
+	require.NoError(t, err)
+	assert.Equal(t, len(team), 2, "number of Pokémon")
+	assert.Equal(t, team[1].Species, "Ferrothorn", "species")
+	assert.Equal(t, team[1].IVs, Stats{31, 31, 31, 31, 31, 0}, "IVs")
+	assert.Equal(t, team[0].TeraType, "Electric", "tera type")
+	assert.Equal(t, Pack(team), packedTeam, "Pack(Unpack(packed))")
+
+	_, err = Unpack("Pikachu|")
+	assert.Error(t, err, "Unpack with missing fields")
+}
+
+func TestStore(t *testing.T) {
+	directory, err := ioutil.TempDir("", "teams")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+	store := NewStore(directory)
+
+	team, err := ParseExport(exportedTeam)
+	require.NoError(t, err)
+	require.NoError(t, store.Save("[Gen 9] OU", "Rain / Sun", team))
+
+	names, err := store.List("gen9ou")
+	require.NoError(t, err)
+	assert.Equal(t, names, []string{"Rain / Sun"}, "List")
+	formats, err := store.Formats()
+	require.NoError(t, err)
+	assert.Equal(t, formats, []string{"gen9ou"}, "Formats")
+
+	loaded, err := store.Load("gen9ou", "Rain / Sun")
+	require.NoError(t, err)
+	assert.Equal(t, loaded, team, "Load")
+
+	require.NoError(t, store.Delete("gen9ou", "Rain / Sun"))
+	names, err = store.List("gen9ou")
+	require.NoError(t, err)
+	assert.Empty(t, names, "List after Delete")
+}
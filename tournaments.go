@@ -0,0 +1,210 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xfix/showdown2irc/irc"
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// showTournamentEvent shows a notice about a |tournament| message, after
+// the tournament state was updated.
+func (c *connection) showTournamentEvent(rawMessage string, room *showdown.Room) {
+	tournament := room.Tournament
+	if tournament == nil {
+		return
+	}
+	c.setLastTournament(room.ID)
+	channel := escapeRoom(room.ID)
+	notice := func(format string, arguments ...interface{}) {
+		c.sendGlobal("NOTICE", channel, fmt.Sprintf(format, arguments...))
+	}
+	args := strings.Split(rawMessage, "|")
+	switch args[0] {
+	case "create":
+		notice("Signups are open for a %s %s tournament. Use /tour join %s to join", tournament.Format, tournament.Generator, channel)
+	case "start":
+		notice("The tournament started")
+	case "updateEnd":
+		c.showTournamentOpponents(room)
+	case "battlestart":
+		if len(args) >= 4 {
+			notice("%s and %s started a tournament battle in %s", args[1], args[2], escapeRoom(showdown.RoomID(args[3])))
+		}
+	case "battleend":
+		if len(args) >= 5 {
+			var score []int
+			for _, part := range strings.Split(args[4], ",") {
+				value, _ := strconv.Atoi(part)
+				score = append(score, value)
+			}
+			notice("%s", showdown.DescribeBattleResult(args[1], args[2], args[3], score))
+		}
+	case "disqualify":
+		if len(args) >= 2 {
+			notice("%s was disqualified", args[1])
+		}
+	case "error":
+		if len(args) >= 2 {
+			notice("Tournament error: %s", args[1])
+		}
+	case "end":
+		notice("\x02The tournament was won by %s", strings.Join(tournament.Winners, ", "))
+		for _, line := range tournament.Bracket.Lines() {
+			notice("%s", line)
+		}
+		delete(c.tournamentNotices, roomKey(room.ID))
+	case "forceend":
+		notice("The tournament was ended")
+		delete(c.tournamentNotices, roomKey(room.ID))
+	}
+}
+
+// showTournamentOpponents tells who the user can battle next in
+// a tournament, when that changes.
+func (c *connection) showTournamentOpponents(room *showdown.Room) {
+	tournament := room.Tournament
+	if !tournament.Joined || !tournament.Started || tournament.Ended {
+		return
+	}
+	channel := escapeRoom(room.ID)
+	var notices []string
+	switch {
+	case tournament.Challenged != "":
+		notices = append(notices, fmt.Sprintf("%s challenged you. Use /tour accept %s", tournament.Challenged, channel))
+	case tournament.Challenging != "":
+		notices = append(notices, fmt.Sprintf("Waiting for %s to accept your challenge", tournament.Challenging))
+	default:
+		if len(tournament.Challenges) != 0 {
+			notices = append(notices, fmt.Sprintf("Your next opponent: %s. Use /tour challenge %s", joinIDs(tournament.Challenges), channel))
+		}
+		if len(tournament.ChallengeBys) != 0 {
+			notices = append(notices, fmt.Sprintf("Waiting for a challenge from %s", joinIDs(tournament.ChallengeBys)))
+		}
+	}
+	key := roomKey(room.ID)
+	shown := strings.Join(notices, "\n")
+	if shown == c.tournamentNotices[key] {
+		return
+	}
+	if c.tournamentNotices == nil {
+		c.tournamentNotices = map[string]string{}
+	}
+	c.tournamentNotices[key] = shown
+	for _, text := range notices {
+		c.sendGlobal("NOTICE", channel, text)
+	}
+}
+
+func joinIDs(ids []showdown.UserID) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = string(id)
+	}
+	return strings.Join(names, ", ")
+}
+
+// tournamentRoom finds a room for a tournament command, either given as
+// the first parameter, or the last room with a tournament. It returns
+// the room, a copy of its tournament and remaining parameters.
+func (c *connection) tournamentRoom(params []string) (*showdown.Room, *showdown.Tournament, []string) {
+	name := string(c.getLastTournament())
+	if len(params) > 0 && strings.HasPrefix(params[0], "#") {
+		name = params[0][1:]
+		params = params[1:]
+	}
+	room, ok := c.showdown.JoinedRoom(name)
+	var tournament *showdown.Tournament
+	if ok {
+		tournament = c.showdown.Tournament(room.ID)
+	}
+	if name == "" || tournament == nil {
+		c.sendNumericReason(irc.ErrNotOnChannel, escapeRoom(showdown.RoomID(name)), "There is no tournament in this room")
+		return nil, nil, nil
+	}
+	return room, tournament, params
+}
+
+// setLastTournament remembers the last room with a tournament.
+func (c *connection) setLastTournament(room showdown.RoomID) {
+	c.lastRoomMutex.Lock()
+	defer c.lastRoomMutex.Unlock()
+	c.lastTournament = room
+}
+
+func (c *connection) getLastTournament() showdown.RoomID {
+	c.lastRoomMutex.Lock()
+	defer c.lastRoomMutex.Unlock()
+	return c.lastTournament
+}
+
+// tournamentSubcommands handle TOUR command, and receive a room,
+// a copy of its tournament, and parameters after a room.
+var tournamentSubcommands = map[string]func(c *connection, room *showdown.Room, tournament *showdown.Tournament, params []string){
+	"JOIN": func(c *connection, room *showdown.Room, tournament *showdown.Tournament, params []string) {
+		room.SendCommand("tour", "join")
+	},
+	"LEAVE": func(c *connection, room *showdown.Room, tournament *showdown.Tournament, params []string) {
+		room.SendCommand("tour", "leave")
+	},
+	"CHALLENGE": func(c *connection, room *showdown.Room, tournament *showdown.Tournament, params []string) {
+		opponents := tournament.Challenges
+		switch {
+		case len(params) > 0:
+			room.SendCommand("tour", "challenge "+unescapeUser(strings.Join(params, " ")))
+		case len(opponents) == 1:
+			room.SendCommand("tour", "challenge "+string(opponents[0]))
+		case len(opponents) == 0:
+			c.sendGlobal("NOTICE", escapeRoom(room.ID), "You don't have anyone to challenge")
+		default:
+			c.sendGlobal("NOTICE", escapeRoom(room.ID), "Choose an opponent: "+joinIDs(opponents))
+		}
+	},
+	"ACCEPT": func(c *connection, room *showdown.Room, tournament *showdown.Tournament, params []string) {
+		room.SendCommand("tour", "acceptchallenge")
+	},
+	"BRACKET": func(c *connection, room *showdown.Room, tournament *showdown.Tournament, params []string) {
+		lines := tournament.Bracket.Lines()
+		if len(lines) == 0 {
+			c.sendGlobal("NOTICE", escapeRoom(room.ID), "The bracket isn't available yet")
+		}
+		for _, line := range lines {
+			c.sendGlobal("NOTICE", escapeRoom(room.ID), line)
+		}
+	},
+}
+
+// tournamentCommand handles TOUR command, like /tour join #room.
+func tournamentCommand(c *connection, command []string) {
+	if len(command) < 1 {
+		c.needMoreParams("TOUR")
+		return
+	}
+	subcommand, ok := tournamentSubcommands[strings.ToUpper(command[0])]
+	if !ok {
+		c.sendGlobal("NOTICE", c.nickname, fmt.Sprintf("Unknown subcommand %s, use JOIN, LEAVE, CHALLENGE, ACCEPT or BRACKET", command[0]))
+		return
+	}
+	room, tournament, params := c.tournamentRoom(command[1:])
+	if room != nil {
+		subcommand(c, room, tournament, params)
+	}
+}
@@ -0,0 +1,95 @@
+// showdown2irc - use Showdown chat with an IRC client
+// Copyright (C) 2016 Konrad Borowski
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/xfix/showdown2irc/html2irc"
+	"github.com/xfix/showdown2irc/showdown"
+)
+
+// uhtmlMessage is an HTML message that can be changed later, used by
+// Showdown for things like polls and tournaments.
+type uhtmlMessage struct {
+	code       string
+	messageIDs []string
+}
+
+// canRedact checks whether a client can be asked to remove a message.
+func (c *connection) canRedact() bool {
+	return c.hasCapability("message-tags") && c.hasCapability("draft/message-redaction")
+}
+
+// redact removes messages that were sent previously. When the client
+// doesn't support removing messages, this does nothing.
+func (c *connection) redact(channel string, messageIDs []string, reason string) {
+	if !c.canRedact() {
+		return
+	}
+	for _, id := range messageIDs {
+		if reason == "" {
+			c.sendGlobal("REDACT", channel, id)
+		} else {
+			c.sendGlobal("REDACT", channel, id, reason)
+		}
+	}
+}
+
+// showUHTML shows a message created with uhtml or uhtmlchange.
+//
+// When a client supports message redaction, the previous version of a
+// message is removed, making it look like an edit. Otherwise, updated
+// message is sent as a new notice.
+func (c *connection) showUHTML(room *showdown.Room, rawMessage string, change bool) {
+	parts := strings.SplitN(rawMessage, "|", 2)
+	if len(parts) != 2 {
+		return
+	}
+	name := parts[0]
+	code := parts[1]
+
+	key := roomKey(room.ID)
+	messages := c.uhtml[key]
+	if messages == nil {
+		messages = map[string]uhtmlMessage{}
+		c.uhtml[key] = messages
+	}
+
+	previous, exists := messages[name]
+	// Showdown client ignores changes of messages it doesn't know
+	// about, so do the same.
+	if change && !exists {
+		return
+	}
+	if exists && previous.code == code {
+		return
+	}
+
+	channel := escapeRoom(room.ID)
+	c.redact(channel, previous.messageIDs, "")
+	if strings.TrimSpace(code) == "" {
+		delete(messages, name)
+		return
+	}
+
+	var messageIDs []string
+	for _, line := range html2irc.HTMLToIRC(code) {
+		messageIDs = append(messageIDs, c.sendWithID(serverName, "NOTICE", channel, line)...)
+	}
+	messages[name] = uhtmlMessage{code: code, messageIDs: messageIDs}
+}